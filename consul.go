@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseConsulEnv splits a consulenv entry into the environment variable name and the
+// consul key. Keys are relative to the job of the project, unless they start with a /.
+func parseConsulEnv(tj *Tjob, e string) (string, string) {
+	name := filepath.Base(e)
+	if strings.Contains(e, "<-") {
+		res := strings.Split(e, "<-")
+		name = res[0]
+		e = res[1]
+	}
+	if strings.HasPrefix(e, "/") {
+		return name, strings.TrimPrefix(e, "/")
+	}
+	return name, parseProject(tj) + "/" + e
+}
+
+// parseDiscover splits a discover entry into the environment variable name and the consul
+// service name. The service is a task name of the project, project/task for another project
+// of the team or team/project/task for another team.
+func parseDiscover(tj *Tjob, e string) (string, string, error) {
+	name := filepath.Base(e)
+	if strings.Contains(e, "<-") {
+		res := strings.Split(e, "<-")
+		name = res[0]
+		e = res[1]
+	}
+	other := *tj
+	other.Suffix = ""
+	parts := strings.Split(e, "/")
+	switch len(parts) {
+	case 1:
+	case 2:
+		other.Project = parts[0]
+	case 3:
+		other.Team = parts[0]
+		other.Project = parts[1]
+	default:
+		return "", "", fmt.Errorf("discover %s: expected task, project/task or team/project/task", e)
+	}
+	return name, getServiceName(&other, parts[len(parts)-1]), nil
+}
+
+// createConsulEnvInject creates consul-taskname-count.env files which contain the necessary information
+// to be injected in the template stanza. It returns the created filename.
+func createConsulEnvInject(tj *Tjob, task Ttask, name string, count int) string {
+	content := ""
+	for _, e := range task.ConsulEnv {
+		env, key := parseConsulEnv(tj, e)
+		content += env + "=\"{{key \"" + key + "\"}}\"\n"
+	}
+	for _, e := range task.Discover {
+		env, service, err := parseDiscover(tj, e)
+		if err != nil {
+			continue
+		}
+		content += env + "=\"{{range $i, $s := service \"" + service + "\"}}{{if $i}},{{end}}[{{.Address}}]:{{.Port}}{{end}}\"\n"
+	}
+	// return nothing if we have no content
+	if content == "" {
+		return ""
+	}
+	f := "consul-" + name + "-" + strconv.Itoa(count) + ".env"
+	ioutil.WriteFile(f, []byte(content), 0600)
+	return f
+}
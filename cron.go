@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a parsed cron expression as used by nomad periodic jobs:
+// minute hour day-of-month month day-of-week [year]
+type cronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	// nil matches every year
+	year             map[int]bool
+	domStar, dowStar bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// parseCron parses a cron expression with 5 fields, 6 fields (with year) or a macro like @daily.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		m, ok := cronMacros[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("cron %s: unknown macro", expr)
+		}
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 && len(fields) != 6 {
+		return nil, fmt.Errorf("cron %s: expected 5 or 6 fields, got %d", expr, len(fields))
+	}
+	c := &cronSchedule{}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %s: minute: %s", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %s: hour: %s", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %s: day of month: %s", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("cron %s: month: %s", expr, err)
+	}
+	// 7 is sunday too
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("cron %s: day of week: %s", expr, err)
+	}
+	c.dow[0] = c.dow[0] || c.dow[7]
+	c.domStar = fields[2] == "*" || fields[2] == "?"
+	c.dowStar = fields[4] == "*" || fields[4] == "?"
+	if len(fields) == 6 && fields[5] != "*" {
+		years, err := parseCronField(fields[5], 1970, 2099, nil)
+		if err != nil {
+			return nil, fmt.Errorf("cron %s: year: %s", expr, err)
+		}
+		c.year = make(map[int]bool)
+		for y, ok := range years {
+			if ok {
+				c.year[y] = true
+			}
+		}
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma separated list of *, values, ranges and steps
+// into a slice indexed by value.
+func parseCronField(field string, min, max int, names map[string]int) ([]bool, error) {
+	res := make([]bool, max+1)
+	for _, item := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("%s: invalid step", item)
+			}
+			item = item[:i]
+		}
+		start, end := min, max
+		switch {
+		case item == "*" || item == "?":
+		case strings.Contains(item, "-"):
+			r := strings.SplitN(item, "-", 2)
+			var err error
+			if start, err = parseCronValue(r[0], min, max, names); err != nil {
+				return nil, err
+			}
+			if end, err = parseCronValue(r[1], min, max, names); err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("%s: invalid range", item)
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(item, min, max, names); err != nil {
+				return nil, err
+			}
+			// a/step means from a to the maximum
+			end = start
+			if step > 1 {
+				end = max
+			}
+		}
+		for v := start; v <= end; v += step {
+			res[v] = true
+		}
+	}
+	return res, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid value", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%s: out of range %d-%d", s, min, max)
+	}
+	return v, nil
+}
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dow
+	case c.dowStar:
+		return dom
+	}
+	return dom || dow
+}
+
+// next returns the first time after t matching the schedule, or the zero time
+// if there is none before 2100.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Year() < 2100 {
+		var skip time.Time
+		switch {
+		case c.year != nil && !c.year[t.Year()]:
+			skip = time.Date(t.Year()+1, 1, 1, 0, 0, 0, 0, loc)
+		case !c.month[int(t.Month())]:
+			skip = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			skip = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour[t.Hour()]:
+			skip = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute[t.Minute()]:
+			skip = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// daylight saving time changes can move the skipped time backwards
+		if !skip.After(t) {
+			skip = t.Add(time.Minute)
+		}
+		t = skip
+	}
+	return time.Time{}
+}
+
+// checkCron returns an error if the cron expression is invalid or never runs after now.
+func checkCron(expr string, now time.Time) error {
+	c, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+	if c.next(now).IsZero() {
+		return fmt.Errorf("cron %s: never runs", expr)
+	}
+	return nil
+}
+
+// getNextRuns returns the next n runs of the periodic job after now, in the time zone of the job.
+func getNextRuns(tj *Tjob, now time.Time, n int) ([]time.Time, error) {
+	p := getPeriodic(tj)
+	crons := p.Crons
+	if p.Cron != "" {
+		crons = []string{p.Cron}
+	}
+	zone := p.TimeZone
+	if zone == "" {
+		zone = "UTC"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	var schedules []*cronSchedule
+	for _, cron := range crons {
+		if err := checkCron(cron, now); err != nil {
+			return nil, err
+		}
+		c, _ := parseCron(cron)
+		schedules = append(schedules, c)
+	}
+	var runs []time.Time
+	t := now.In(loc)
+	for len(runs) < n && len(schedules) > 0 {
+		// the earliest next run of all the crons
+		var next time.Time
+		for _, c := range schedules {
+			if r := c.next(t); !r.IsZero() && (next.IsZero() || r.Before(next)) {
+				next = r
+			}
+		}
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+		t = next
+	}
+	return runs, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		ok   bool
+	}{
+		{"@daily", true},
+		{"@YEARLY", true},
+		{"@often", false},
+		{"0 3 * * *", true},
+		{"*/15 * * * *", true},
+		{"0 9-17 * * 1-5", true},
+		{"0 0 1,15 * *", true},
+		{"30 2 * JAN-MAR MON-FRI", true},
+		{"0 0 * * 7", true},
+		{"0 0 ? * SUN", true},
+		{"10/20 * * * *", true},
+		{"0 0 1 1 * 2030", true},
+		{"0 0 1 1 * 2030-2040/2", true},
+		{"0 0 1 1 * *", true},
+		{"0 0 1 1 * 1969", false},
+		{"0 0 1 1 * 2100", false},
+		{"60 * * * *", false},
+		{"0 24 * * *", false},
+		{"0 0 0 * *", false},
+		{"0 0 32 * *", false},
+		{"0 0 * 13 *", false},
+		{"0 0 * * 8", false},
+		{"0 0 * FOO *", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"1 2 3", false},
+		{"1 2 3 4 5 6 7", false},
+	}
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr)
+		if tt.ok && err != nil {
+			t.Errorf("%s: unexpected error %s", tt.expr, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("%s: expected an error", tt.expr)
+		}
+	}
+}
+
+func TestParseCronFields(t *testing.T) {
+	c, err := parseCron("*/15 9-11 * JUN SUN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for m := 0; m < 60; m++ {
+		if c.minute[m] != (m%15 == 0) {
+			t.Errorf("minute %d: got %v", m, c.minute[m])
+		}
+	}
+	for h := 0; h < 24; h++ {
+		if c.hour[h] != (h >= 9 && h <= 11) {
+			t.Errorf("hour %d: got %v", h, c.hour[h])
+		}
+	}
+	if !c.month[6] || c.month[7] {
+		t.Errorf("month: expected only june")
+	}
+	if !c.dow[0] || c.dow[1] {
+		t.Errorf("day of week: expected only sunday")
+	}
+	if !c.domStar || c.dowStar {
+		t.Errorf("expected day of month * and a day of week")
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	brussels, err := time.LoadLocation("Europe/Brussels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", time.Date(2026, 10, 19, 10, 7, 30, 0, time.UTC), []time.Time{
+			time.Date(2026, 10, 19, 10, 15, 0, 0, time.UTC),
+			time.Date(2026, 10, 19, 10, 30, 0, 0, time.UTC),
+		}},
+		{"strictly after from", "0 3 * * *", time.Date(2026, 10, 19, 3, 0, 0, 0, time.UTC), []time.Time{
+			time.Date(2026, 10, 20, 3, 0, 0, 0, time.UTC),
+		}},
+		{"leap day", "0 0 29 2 *", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), []time.Time{
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			time.Date(2032, 2, 29, 0, 0, 0, 0, time.UTC),
+		}},
+		// monday 19 october, runs on fridays and on the 13th
+		{"day of month or day of week", "0 0 13 * FRI", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), []time.Time{
+			time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 10, 30, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 11, 6, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 11, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 11, 20, 0, 0, 0, 0, time.UTC),
+		}},
+		{"year field", "0 0 1 1 * 2030", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), []time.Time{
+			time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+			{},
+		}},
+		{"never", "0 0 30 2 *", time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), []time.Time{
+			{},
+		}},
+		// 02:30 doesn't exist on 29 march 2026, the clock jumps from 02:00 to 03:00
+		{"dst gap", "30 2 * * *", time.Date(2026, 3, 28, 12, 0, 0, 0, brussels), []time.Time{
+			time.Date(2026, 3, 30, 2, 30, 0, 0, brussels),
+		}},
+		{"dst gap hourly", "0 * * * *", time.Date(2026, 3, 29, 1, 10, 0, 0, brussels), []time.Time{
+			time.Date(2026, 3, 29, 3, 0, 0, 0, brussels),
+			time.Date(2026, 3, 29, 4, 0, 0, 0, brussels),
+		}},
+		// 02:30 happens twice on 25 october 2026, it runs once
+		{"dst end", "30 2 * * *", time.Date(2026, 10, 25, 1, 0, 0, 0, brussels), []time.Time{
+			time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC),
+			time.Date(2026, 10, 26, 2, 30, 0, 0, brussels),
+		}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		from := tt.from
+		for i, want := range tt.want {
+			got := c.next(from)
+			if !got.Equal(want) {
+				t.Errorf("%s: run %d: expected %s, got %s", tt.name, i, want, got)
+				break
+			}
+			from = got
+		}
+	}
+}
+
+func TestCheckCron(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4 *", "0 0 1 1 * 2020", "61 * * * *"} {
+		if err := checkCron(expr, now); err == nil {
+			t.Errorf("%s: expected an error", expr)
+		}
+	}
+	for _, expr := range []string{"0 0 29 2 *", "0 0 31 * *", "@hourly"} {
+		if err := checkCron(expr, now); err != nil {
+			t.Errorf("%s: unexpected error %s", expr, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+// default ephemeral disk size of nomad in MB
+const defaultDiskMB = 300
+
+// getEphemeralDisk returns the ephemeral_disk stanza of the taskgroup, or nil when the
+// taskgroup uses the nomad defaults.
+func getEphemeralDisk(tg *Tgroup) *EphemeralDisk {
+	if tg.DiskMB == 0 && !tg.StickyDisk && !tg.MigrateDisk {
+		return nil
+	}
+	size := tg.DiskMB
+	if size == 0 {
+		size = defaultDiskMB
+	}
+	return &EphemeralDisk{Size: size, Sticky: tg.StickyDisk, Migrate: tg.MigrateDisk}
+}
+
+// getMigrate returns the migrate stanza of the taskgroup, or nil when there is no
+// [taskgroup.migrate].
+func getMigrate(tg *Tgroup) *Migrate {
+	t := tg.Migrate
+	if t == (Tmigrate{}) {
+		return nil
+	}
+	m := &Migrate{MaxParallel: 1, HealthCheck: t.HealthCheck, MinHealthyTime: t.MinHealthyTime, HealthyDeadline: t.HealthyDeadline}
+	if t.MaxParallel != nil {
+		m.MaxParallel = *t.MaxParallel
+	}
+	return m
+}
+
+// getDiskTotal returns the ephemeral disk in MB requested by all the allocations of the job,
+// system jobs are counted once per taskgroup.
+func getDiskTotal(tj *Tjob) int {
+	total := 0
+	for _, tg := range tj.Taskgroup {
+		size := defaultDiskMB
+		if tg.DiskMB != 0 {
+			size = tg.DiskMB
+		}
+		count := tg.Count
+		if count == 0 || isSystem(tj) {
+			count = 1
+		}
+		total += size * count
+	}
+	return total
+}
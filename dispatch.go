@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+)
+
+// getDispatchExample returns a nomad job dispatch command for a parameterized job,
+// with the required meta keys and the optional ones commented.
+func getDispatchExample(tj *Tjob, tier string) string {
+	job := parseJob(tj)
+	if tier != "" {
+		job = strings.Replace(job, "${short_tier}", getShortTier(tier), -1)
+	}
+	cmd := "nomad job dispatch"
+	for _, key := range tj.Parameterized.MetaRequired {
+		cmd += " -meta " + key + "=<" + key + ">"
+	}
+	cmd += " " + job
+	switch tj.Parameterized.Payload {
+	case "required":
+		cmd += " <payload-file>"
+	case "", "optional":
+		cmd += " [<payload-file>]"
+	}
+	if len(tj.Parameterized.MetaOptional) > 0 {
+		cmd = "# optional meta: " + strings.Join(tj.Parameterized.MetaOptional, ", ") + "\n" + cmd
+	}
+	return cmd
+}
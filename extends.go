@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// findExtends returns the profile or else the task named name, with a key identifying it.
+// A task name used in several taskgroups is ambiguous.
+func findExtends(tj *Tjob, name string) (Ttask, string, error) {
+	for _, profile := range tj.Profile {
+		if profile.Name == name {
+			return profile, "profile " + name, nil
+		}
+	}
+	var found []Ttask
+	var groups []string
+	for _, task := range tj.Task {
+		if task.Name == name {
+			found = append(found, task)
+			groups = append(groups, task.Taskgroup)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Ttask{}, "", fmt.Errorf("extends %s: unknown profile or task", name)
+	case 1:
+		return found[0], "task " + name, nil
+	}
+	return Ttask{}, "", fmt.Errorf("extends %s: ambiguous, task %s exists in taskgroups %s", name, name, strings.Join(groups, ", "))
+}
+
+// resolveTask returns the task merged with the profile or task it extends.
+// chain contains the keys (see findExtends) of the task and the entries extending it.
+func resolveTask(tj *Tjob, task Ttask, chain []string) (Ttask, error) {
+	if task.Extends == "" {
+		return task, nil
+	}
+	parent, key, err := findExtends(tj, task.Extends)
+	if err != nil {
+		return task, err
+	}
+	for i, k := range chain {
+		if k == key {
+			return task, fmt.Errorf("extends %s: cycle %s", task.Extends, strings.Join(append(chain[i:], key), " -> "))
+		}
+	}
+	parent, err = resolveTask(tj, parent, append(chain, key))
+	if err != nil {
+		return task, err
+	}
+	return mergeTask(parent, task), nil
+}
+
+// resolveExtends merges every task with the profile or task it extends.
+// Tasks with unknown references or cycles are kept as is and reported by validateJob.
+func resolveExtends(tj *Tjob) {
+	tasks := make([]Ttask, len(tj.Task))
+	for i, task := range tj.Task {
+		tasks[i] = task
+		if t, err := resolveTask(tj, task, []string{"task " + task.Name}); err == nil {
+			tasks[i] = t
+		}
+	}
+	tj.Task = tasks
+}
+
+// mergeTask merges a task into its parent. Settings of the task override the parent,
+// env, labels, sysctl and ulimit are merged by key and volumes, mounts and inject are appended.
+func mergeTask(parent, task Ttask) Ttask {
+	p := reflect.ValueOf(&parent).Elem()
+	t := reflect.ValueOf(task)
+	for i := 0; i < p.NumField(); i++ {
+		name := p.Type().Field(i).Name
+		pf, tf := p.Field(i), t.Field(i)
+		switch name {
+		case "Name", "Extends":
+			pf.Set(tf)
+		case "Env", "Labels", "Sysctl", "Ulimit":
+			pf.Set(reflect.ValueOf(mergeKeyValues(pf.Interface().([]string), tf.Interface().([]string))))
+		case "Volumes", "Inject":
+			pf.Set(reflect.ValueOf(mergeUnique(pf.Interface().([]string), tf.Interface().([]string))))
+		case "Mount":
+			pf.Set(reflect.AppendSlice(pf, tf))
+		default:
+			// booleans are optional (*bool), so an explicit false overrides the parent
+			if !isZero(tf) {
+				pf.Set(tf)
+			}
+		}
+	}
+	return parent
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Ptr:
+		return v.IsNil() || (v.Kind() != reflect.Ptr && v.Len() == 0)
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// mergeKeyValues merges key=value lists, keys in list override the same keys in parent.
+func mergeKeyValues(parent, list []string) []string {
+	keys := make(map[string]bool)
+	for _, kv := range list {
+		keys[strings.TrimSpace(strings.SplitN(kv, "=", 2)[0])] = true
+	}
+	var res []string
+	for _, kv := range parent {
+		if !keys[strings.TrimSpace(strings.SplitN(kv, "=", 2)[0])] {
+			res = append(res, kv)
+		}
+	}
+	return append(res, list...)
+}
+
+// mergeUnique appends list to parent, skipping duplicates.
+func mergeUnique(parent, list []string) []string {
+	seen := make(map[string]bool)
+	var res []string
+	for _, s := range append(append([]string{}, parent...), list...) {
+		if !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
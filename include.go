@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// getFragments returns the config fragments to merge: the include patterns in the listed
+// order (matches sorted lexically) followed by nomadgen.d/*.toml in lexical order.
+func getFragments(tj *Tjob) ([]string, error) {
+	var files []string
+	for _, pattern := range append(tj.Include, "nomadgen.d/*.toml") {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %s: %s", pattern, err)
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// readfragments merges the include files and nomadgen.d fragments into the job.
+// Taskgroups, tasks, profiles, identities and [[extrajob]] tables are appended, a taskgroup,
+// task or profile can only be defined once. Other settings in nomadgen.toml take precedence over the
+// fragments, fragments setting the same setting to a different value conflict.
+func readfragments(tj *Tjob) {
+	files, err := getFragments(tj)
+	if err != nil {
+		fmt.Printf("error: config file: %s\n", err)
+		os.Exit(1)
+	}
+	var errs []error
+	setBy := make(map[string]string)
+	for _, f := range files {
+		v := viper.New()
+		v.SetConfigFile(f)
+		if err := v.ReadInConfig(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", f, err))
+			continue
+		}
+		var frag Tjob
+		v.Unmarshal(&frag)
+		errs = append(errs, mergeFragment(tj, &frag, f, setBy)...)
+	}
+	for _, err := range errs {
+		fmt.Printf("error: config file: %s\n", err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+func mergeFragment(tj, frag *Tjob, file string, setBy map[string]string) []error {
+	var errs []error
+	if len(frag.Include) > 0 {
+		errs = append(errs, fmt.Errorf("%s: include is only supported in nomadgen.toml", file))
+	}
+	for _, tg := range frag.Taskgroup {
+		for _, existing := range tj.Taskgroup {
+			if existing.Name == tg.Name {
+				errs = append(errs, fmt.Errorf("%s: duplicate taskgroup %s", file, tg.Name))
+			}
+		}
+	}
+	for _, task := range frag.Task {
+		for _, existing := range tj.Task {
+			if existing.Taskgroup == task.Taskgroup && existing.Name == task.Name {
+				errs = append(errs, fmt.Errorf("%s: duplicate task %s in taskgroup %s", file, task.Name, task.Taskgroup))
+			}
+		}
+	}
+	for _, profile := range frag.Profile {
+		for _, existing := range tj.Profile {
+			if existing.Name == profile.Name {
+				errs = append(errs, fmt.Errorf("%s: duplicate profile %s", file, profile.Name))
+			}
+		}
+	}
+	t := reflect.ValueOf(tj).Elem()
+	fr := reflect.ValueOf(frag).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Type().Field(i).Name
+		tf, ff := t.Field(i), fr.Field(i)
+		switch name {
+		case "Include":
+			continue
+		case "Taskgroup", "Task", "Profile", "Identity", "ExtraJob":
+			tf.Set(reflect.AppendSlice(tf, ff))
+			continue
+		}
+		if isZero(ff) {
+			continue
+		}
+		if isZero(tf) {
+			tf.Set(ff)
+			setBy[name] = file
+			continue
+		}
+		// nomadgen.toml takes precedence
+		if setBy[name] == "" {
+			continue
+		}
+		if !reflect.DeepEqual(tf.Interface(), ff.Interface()) {
+			errs = append(errs, fmt.Errorf("%s: %s conflicts with %s", file, strings.ToLower(name), setBy[name]))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// an inject entry as file:option:option
+type injectEntry struct {
+	File string
+	// inject the file as environment variables
+	Env bool
+	// the file is not a consul-template and must be injected as is
+	Raw bool
+	// the file is a consul-template
+	Template bool
+	// the file is downloaded as an artifact
+	Artifact bool
+	// paths in the container where the file is mounted
+	Targets []string
+}
+
+func parseInjectEntry(input string) injectEntry {
+	splitInput := strings.Split(input, ":")
+	entry := injectEntry{File: splitInput[0]}
+	for _, opt := range splitInput[1:] {
+		switch opt {
+		case "env":
+			entry.Env = true
+		case "raw":
+			entry.Raw = true
+		case "template":
+			entry.Template = true
+		case "artifact":
+			entry.Artifact = true
+		}
+		// if we have a / starting it means we want to overwrite a file in the container
+		if strings.HasPrefix(opt, "/") {
+			entry.Targets = append(entry.Targets, opt)
+		}
+	}
+	if strings.HasSuffix(entry.File, ".env") {
+		entry.Env = true
+	}
+	return entry
+}
+
+// expandInjectEntry expands an entry with a glob pattern or a directory into an entry per
+// matched file. The relative path of a file is appended to targets ending in a /.
+func expandInjectEntry(entry injectEntry) ([]injectEntry, error) {
+	var files []string
+	base := ""
+	fi, err := os.Stat(entry.File)
+	switch {
+	case err == nil && fi.IsDir():
+		base = entry.File
+		err = filepath.Walk(entry.File, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	case strings.ContainsAny(entry.File, "*?["):
+		base = getGlobBase(entry.File)
+		files, err = filepath.Glob(entry.File)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		base = filepath.Dir(entry.File)
+		files = []string{entry.File}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s matches no files", entry.File)
+	}
+	var entries []injectEntry
+	for _, f := range files {
+		e := entry
+		e.File = filepath.ToSlash(f)
+		e.Targets = nil
+		rel, err := filepath.Rel(base, f)
+		if err != nil {
+			return nil, err
+		}
+		for _, target := range entry.Targets {
+			if strings.HasSuffix(target, "/") {
+				target += filepath.ToSlash(rel)
+			}
+			e.Targets = append(e.Targets, target)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// getGlobBase returns the leading directories of a glob pattern without special characters.
+func getGlobBase(pattern string) string {
+	base := []string{}
+	for _, dir := range strings.Split(filepath.Dir(pattern), string(filepath.Separator)) {
+		if strings.ContainsAny(dir, "*?[") {
+			break
+		}
+		base = append(base, dir)
+	}
+	if len(base) == 0 {
+		return "."
+	}
+	return filepath.Join(base...)
+}
+
+// applyTemplateSettings applies the [[task.template]] settings to the templates of the files
+// matching their file pattern. Later settings override earlier ones.
+func applyTemplateSettings(templates []Template, settings []Ttemplate) []Template {
+	for i, t := range templates {
+		file := strings.TrimPrefix(t.Destination, "secrets/")
+		for _, ts := range settings {
+			if ok, _ := filepath.Match(ts.File, file); !ok {
+				continue
+			}
+			if ts.ChangeMode != "" {
+				t.ChangeMode = ts.ChangeMode
+			}
+			if ts.ChangeSignal != "" {
+				t.ChangeSignal = ts.ChangeSignal
+			}
+			if ts.ChangeScript.Command != "" {
+				t.ChangeScript = &ChangeScript{
+					Command:     ts.ChangeScript.Command,
+					Args:        ts.ChangeScript.Args,
+					Timeout:     ts.ChangeScript.Timeout,
+					FailOnError: ts.ChangeScript.FailOnError,
+				}
+			}
+			if ts.Splay != "" {
+				t.Splay = ts.Splay
+			}
+			if ts.Perms != "" {
+				t.Perms = ts.Perms
+			}
+			if ts.UID != nil {
+				t.UID = ts.UID
+			}
+			if ts.GID != nil {
+				t.GID = ts.GID
+			}
+			if ts.VaultGrace != "" {
+				t.VaultGrace = ts.VaultGrace
+			}
+		}
+		templates[i] = t
+	}
+	return templates
+}
+
+// delimiters used for raw files, the first pair not found in the content is used
+var rawDelimiters = [][2]string{
+	{"[[", "]]"},
+	{"<%", "%>"},
+	{"{%", "%}"},
+	{"@@{", "}@@"},
+}
+
+// getRawTemplate makes sure consul-template renders content as is. It returns the
+// left and right delimiter to use (empty for the defaults) and the content.
+// If all the alternative delimiters are used in the content, the default delimiters
+// in the content are escaped instead.
+func getRawTemplate(content string) (string, string, string) {
+	if !strings.Contains(content, "{{") && !strings.Contains(content, "}}") {
+		return "", "", content
+	}
+	for _, d := range rawDelimiters {
+		if !strings.Contains(content, d[0]) && !strings.Contains(content, d[1]) {
+			return d[0], d[1], content
+		}
+	}
+	r := strings.NewReplacer("{{", `{{"{{"}}`, "}}", `{{"}}"}}`)
+	return "", "", r.Replace(content)
+}
+
+// useArtifact returns true if the file must be injected as an artifact instead of a template,
+// because it is asked for, or too large or not valid UTF-8 and an artifacturl is configured.
+func useArtifact(entry injectEntry, content []byte) bool {
+	return entry.Artifact || site.ArtifactURL != "" && needsArtifact(content)
+}
+
+// needsArtifact returns true if the file is too large or not valid UTF-8 to inline in a template.
+func needsArtifact(content []byte) bool {
+	return len(content) > site.InjectMaxSize || !utf8.Valid(content)
+}
+
+// publishArtifact copies the file to the artifact directory, in a subdirectory named after
+// its sha256 checksum, and returns the artifact stanza downloading it in the task.
+func publishArtifact(entry injectEntry, content []byte) (Artifact, error) {
+	if site.ArtifactURL == "" {
+		return Artifact{}, errors.New("no artifacturl configured in the site defaults")
+	}
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+	dir := filepath.Join(site.ArtifactDir, checksum)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Artifact{}, err
+	}
+	name := filepath.Base(entry.File)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{
+		Source:      strings.TrimSuffix(site.ArtifactURL, "/") + "/" + checksum + "/" + name,
+		Destination: "secrets/" + entry.File,
+		Mode:        "file",
+		Options:     map[string]string{"checksum": "sha256:" + checksum},
+	}, nil
+}
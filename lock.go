@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const lockFile = "nomadgen.lock"
+
+// manifest types accepted when resolving a digest, a manifest list resolves to the
+// digest of the list so every platform keeps working
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+var challengeRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// digests of the locked images, read from nomadgen.lock
+var locked map[string]string
+
+// readlock reads the image digests from nomadgen.lock. A missing lock file locks nothing.
+func readlock() {
+	locked = make(map[string]string)
+	content, err := ioutil.ReadFile(lockFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("error: lock file: %s\n", err)
+		os.Exit(1)
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], "sha256:") {
+			fmt.Printf("error: lock file: line %d: expected image sha256:digest\n", i+1)
+			os.Exit(1)
+		}
+		locked[fields[0]] = fields[1]
+	}
+}
+
+// getLockedImage returns the image pinned to the digest in the lock file and true,
+// or the image as is and false when it isn't locked.
+func getLockedImage(image string) (string, bool) {
+	digest, ok := locked[image]
+	if !ok {
+		return image, false
+	}
+	repo, _ := splitImage(image)
+	return repo + "@" + digest, true
+}
+
+// splitImage splits an image in its repository and its tag or digest.
+func splitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, "latest"
+}
+
+// splitRegistry splits a repository in its registry host and its name in the registry.
+func splitRegistry(repo string) (string, string) {
+	res := strings.SplitN(repo, "/", 2)
+	if len(res) == 2 && (strings.ContainsAny(res[0], ".:") || res[0] == "localhost") {
+		return strings.ToLower(res[0]), res[1]
+	}
+	return "docker.io", repo
+}
+
+// writeLock resolves the images of all tasks to their digest and writes them to nomadgen.lock.
+// It returns false if an image can't be resolved, the lock file is not written then.
+func writeLock(jobs []Tjob) bool {
+	m := make(map[string]bool)
+	for _, tj := range jobs {
+		for _, task := range tj.Task {
+			if task.Image != "" {
+				m[task.Image] = true
+			}
+		}
+	}
+	images := []string{}
+	for image := range m {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	client := &http.Client{Timeout: 30 * time.Second}
+	content := "# image digests locked by nomadgen lock, used by nomadgen write\n"
+	ok := true
+	for _, image := range images {
+		if strings.Contains(image, "${") {
+			fmt.Printf("%s: not locked, the image contains variables\n", image)
+			continue
+		}
+		digest, err := resolveDigest(client, image)
+		if err != nil {
+			fmt.Printf("error: %s: %s\n", image, err)
+			ok = false
+			continue
+		}
+		content += image + " " + digest + "\n"
+		fmt.Printf("%s locked to %s\n", image, digest)
+	}
+	if !ok {
+		return false
+	}
+	if err := ioutil.WriteFile(lockFile, []byte(content), 0644); err != nil {
+		fmt.Printf("error: %s\n", err)
+		return false
+	}
+	fmt.Println(lockFile + " written.")
+	return true
+}
+
+// resolveDigest returns the digest of the manifest of the image with the registry v2 api.
+func resolveDigest(client *http.Client, image string) (string, error) {
+	repo, ref := splitImage(image)
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref, nil
+	}
+	host, name := splitRegistry(repo)
+	r, _ := getRegistry(image)
+	scheme := "https"
+	if r.Insecure {
+		scheme = "http"
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	u := scheme + "://" + host + "/v2/" + name + "/manifests/" + ref
+	resp, err := getManifest(client, "HEAD", u, "")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		auth, err := getRegistryAuth(client, r, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		resp, err = getManifest(client, "HEAD", u, auth)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if digest := resp.Header.Get("Docker-Content-Digest"); resp.StatusCode == http.StatusOK && digest != "" {
+			return digest, nil
+		}
+		// some registries only return the digest for a GET
+		return getManifestDigest(client, u, auth)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); resp.StatusCode == http.StatusOK && digest != "" {
+		return digest, nil
+	}
+	return getManifestDigest(client, u, "")
+}
+
+func getManifest(client *http.Client, method, u, auth string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	return client.Do(req)
+}
+
+// getManifestDigest downloads the manifest and returns its digest.
+func getManifestDigest(client *http.Client, u, auth string) (string, error) {
+	resp, err := getManifest(client, "GET", u, auth)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", errors.New("manifest not found")
+	default:
+		return "", fmt.Errorf("registry returned %s", resp.Status)
+	}
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// getRegistryAuth answers the authentication challenge of the registry and returns the
+// Authorization header to use. The credentials of the registry are read from vault
+// (VAULT_ADDR and VAULT_TOKEN env), without them an anonymous token is requested.
+func getRegistryAuth(client *http.Client, r Tregistry, challenge string) (string, error) {
+	username, password, err := getRegistryCredentials(client, r)
+	if err != nil {
+		return "", err
+	}
+	params := make(map[string]string)
+	for _, m := range challengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[strings.ToLower(m[1])] = m[2]
+	}
+	if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+		if username == "" {
+			return "", errors.New("registry needs credentials")
+		}
+		req, _ := http.NewRequest("GET", "", nil)
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	}
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer") || params["realm"] == "" {
+		return "", fmt.Errorf("unsupported authentication %s", challenge)
+	}
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	}
+	req, err := http.NewRequest("GET", params["realm"]+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// getRegistryCredentials returns the username and password of the registry in vault,
+// or empty credentials when the registry has none or VAULT_ADDR is not set.
+func getRegistryCredentials(client *http.Client, r Tregistry) (string, string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if r.VaultPath == "" || addr == "" {
+		return "", "", nil
+	}
+	data, _, err := readVaultSecret(client, addr, os.Getenv("VAULT_TOKEN"), r.VaultPath)
+	if err != nil {
+		return "", "", fmt.Errorf("credentials %s: %s", r.VaultPath, err)
+	}
+	username, _ := data["username"].(string)
+	password, _ := data["password"].(string)
+	return username, password, nil
+}
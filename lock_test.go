@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+// newRegistryServer returns a registry stand-in with a public repository, a repository
+// behind a bearer token and a repository without Docker-Content-Digest header.
+func newRegistryServer(t *testing.T, manifest []byte) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if r.URL.Query().Get("scope") != "repository:team/private:pull" || r.URL.Query().Get("service") != "registry" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "secret-token"})
+		case r.URL.Path == "/v2/team/public/manifests/1.0":
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case r.URL.Path == "/v2/team/private/manifests/latest":
+			if r.Header.Get("Authorization") != "Bearer secret-token" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="registry",scope="repository:team/private:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		case r.URL.Path == "/v2/team/nodigest/manifests/2":
+			if !strings.Contains(r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json") {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			if r.Method == "GET" {
+				w.Write(manifest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return srv
+}
+
+func TestResolveDigest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	sum := sha256.Sum256(manifest)
+	srv := newRegistryServer(t, manifest)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	saved := site
+	defer func() { site = saved }()
+	site.Registry = []Tregistry{{Host: host, Insecure: true}}
+
+	tests := []struct {
+		image  string
+		digest string
+		err    string
+	}{
+		{host + "/team/public:1.0", testDigest, ""},
+		{host + "/team/private", testDigest, ""},
+		{host + "/team/nodigest:2", "sha256:" + hex.EncodeToString(sum[:]), ""},
+		{host + "/team/missing:1", "", "manifest not found"},
+		{host + "/team/public@" + testDigest, testDigest, ""},
+	}
+	for _, tt := range tests {
+		digest, err := resolveDigest(srv.Client(), tt.image)
+		switch {
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%s: expected error %q, got %v", tt.image, tt.err, err)
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", tt.image, err)
+		case digest != tt.digest:
+			t.Errorf("%s: expected digest %s, got %s", tt.image, tt.digest, digest)
+		}
+	}
+}
+
+func TestGetLockedImage(t *testing.T) {
+	saved := locked
+	defer func() { locked = saved }()
+	locked = map[string]string{
+		"redis:7":                       testDigest,
+		"registry.example.com:5000/app": testDigest,
+	}
+	tests := []struct {
+		image  string
+		want   string
+		locked bool
+	}{
+		{"redis:7", "redis@" + testDigest, true},
+		{"registry.example.com:5000/app", "registry.example.com:5000/app@" + testDigest, true},
+		{"redis:6", "redis:6", false},
+	}
+	for _, tt := range tests {
+		got, ok := getLockedImage(tt.image)
+		if got != tt.want || ok != tt.locked {
+			t.Errorf("%s: expected %s %v, got %s %v", tt.image, tt.want, tt.locked, got, ok)
+		}
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/42wim/hclencoder"
 	"github.com/spf13/viper"
@@ -16,55 +18,213 @@ import (
 
 // toml input
 type Ttask struct {
-	Name          string
-	Taskgroup     string
-	NagiosSms     *int
-	NagiosMail    *int
-	Hostname      string
-	Image         string
-	Args          []string
-	Command       string
-	NoForcePull   bool
-	Volumes       []string
-	Inject        []string
-	Port          int
-	Tags          []string
-	Porttype      string
-	CheckPath     string
-	Grace         string
-	CPU           int
-	Memory        int
-	Firewall      string
-	Labels        []string
-	VaultPolicies []string
-	VaultEnv      []string
-	VaultInject   []string
-	Env           []string
-	Service       []Tservice
+	Name            string
+	Taskgroup       string
+	NagiosSms       *int
+	NagiosMail      *int
+	Hostname        string
+	Image           string
+	Args            []string
+	Command         string
+	NoForcePull     *bool
+	Volumes         []string
+	Inject          []string
+	Port            int
+	Tags            []string
+	Porttype        string
+	CheckPath       string
+	Grace           string
+	CPU             int
+	Memory          int
+	Firewall        string
+	Labels          []string
+	VaultPolicies   []string
+	VaultEnv        []string
+	VaultInject     []string
+	VaultRole       string
+	Identity        []Tidentity
+	ConsulEnv       []string
+	Discover        []string
+	Env             []string
+	Service         []Tservice
+	Template        []Ttemplate
+	Lifecycle       string
+	Sidecar         *bool
+	Extends         string
+	DispatchPayload string
+	Restart         Trestart
+	VolumeMount     []Tvolumemount
+	Mount           []Tmount
+	Entrypoint      []string
+	WorkDir         string
+	User            string
+	CapAdd          []string
+	CapDrop         []string
+	ReadonlyRootfs  *bool
+	Sysctl          []string
+	Ulimit          []string
+	ShmSize         int
+	Init            *bool
+	DNSServers      []string
+	ExtraHosts      []string
+	SecurityOpt     []string
+	Privileged      *bool
+}
+
+type Tmount struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+	// size of a tmpfs mount in bytes
+	Size int
+}
+
+type Tvolumemount struct {
+	Volume      string
+	Destination string
+	ReadOnly    bool
+}
+
+type Ttemplate struct {
+	File         string
+	ChangeMode   string
+	ChangeSignal string
+	ChangeScript Tchangescript
+	Splay        string
+	Perms        string
+	UID          *int
+	GID          *int
+	VaultGrace   string
+}
+
+type Tchangescript struct {
+	Command     string
+	Args        []string
+	Timeout     string
+	FailOnError bool
+}
+
+type Tidentity struct {
+	Name         string
+	Aud          []string
+	Env          bool
+	File         bool
+	TTL          string
+	ChangeMode   string
+	ChangeSignal string
 }
 
 type Tgroup struct {
-	Name       string
-	Count      int
-	Canary     int
-	AutoRevert bool
+	Name        string
+	Count       int
+	Canary      int
+	AutoRevert  bool
+	Strategy    string
+	Sidecars    []string
+	Update      Tupdate
+	Restart     Trestart
+	Reschedule  Treschedule
+	DiskMB      int
+	StickyDisk  bool
+	MigrateDisk bool
+	Migrate     Tmigrate
+	Volume      []Tvolume
+}
+
+type Tvolume struct {
+	Name           string
+	Type           string
+	Source         string
+	ReadOnly       bool
+	AccessMode     string
+	AttachmentMode string
+	PerAlloc       bool
+}
+
+type Tmigrate struct {
+	MaxParallel     *int
+	HealthCheck     string
+	MinHealthyTime  string
+	HealthyDeadline string
+}
+
+type Trestart struct {
+	Attempts *int
+	Interval string
+	Delay    string
+	Mode     string
+}
+
+type Treschedule struct {
+	Attempts      *int
+	Interval      string
+	Delay         string
+	DelayFunction string
+	MaxDelay      string
+	Unlimited     *bool
+}
+
+type Tupdate struct {
+	MaxParallel      *int
+	HealthCheck      string
+	MinHealthyTime   string
+	HealthyDeadline  string
+	ProgressDeadline string
+	AutoPromote      bool
+	Stagger          string
 }
 
 type Tjob struct {
-	Job          string
-	Team         string
-	Project      string
-	Contact      string
-	Tier         string
-	Type         string
-	Cron         string
-	Taskgroup    []Tgroup
-	Task         []Ttask
-	Jenkins      Jenkins
-	Mattermost   string
-	NoBuildLabel bool
-	Organization string
-	AutoRevert   bool
+	Job           string
+	Team          string
+	Project       string
+	Contact       string
+	Tier          string
+	Type          string
+	Cron          string
+	Taskgroup     []Tgroup
+	Task          []Ttask
+	Jenkins       Jenkins
+	Mattermost    string
+	NoBuildLabel  bool
+	Organization  string
+	AutoRevert    bool
+	VaultRole     string
+	Identity      []Tidentity
+	Profile       []Ttask
+	Include       []string
+	ExtraJob      []Tsubjob
+	Parameterized Tparameterized
+	Periodic      Tperiodic
+	Update        Tupdate
+	// name of the [[extrajob]] table, set by splitJobs
+	Suffix string `mapstructure:"-"`
+}
+
+// an [[extrajob]] table, an extra job of the project
+type Tsubjob struct {
+	Name          string
+	Type          string
+	Cron          string
+	Periodic      Tperiodic
+	AutoRevert    bool
+	Parameterized Tparameterized
+	Update        Tupdate
+	Taskgroup     []Tgroup
+	Task          []Ttask
+}
+
+type Tperiodic struct {
+	Crons           []string
+	TimeZone        string
+	ProhibitOverlap *bool
+}
+
+type Tparameterized struct {
+	Payload      string
+	MetaRequired []string
+	MetaOptional []string
 }
 
 type Tservice struct {
@@ -88,19 +248,28 @@ type Root struct {
 }
 
 type JobInfo struct {
-	Name        string       `hcl:",key"`
-	Datacenters []string     `hcl:"datacenters"`
-	Meta        Meta         `hcl:"meta"`
-	Type        string       `hcl:"type" hcle:"omitempty"`
-	Periodic    Periodic     `hcl:"periodic" hcle:"omitempty"`
-	Constraint  []Constraint `hcl:"constraint"`
-	Update      Update       `hcl:"update" hcle:"omitempty" `
-	Group       []GroupInfo  `hcl:"group"`
+	Name          string        `hcl:",key"`
+	Datacenters   []string      `hcl:"datacenters"`
+	Meta          Meta          `hcl:"meta"`
+	Type          string        `hcl:"type" hcle:"omitempty"`
+	Periodic      Periodic      `hcl:"periodic" hcle:"omitempty"`
+	Parameterized Parameterized `hcl:"parameterized" hcle:"omitempty"`
+	Constraint    []Constraint  `hcl:"constraint"`
+	Update        Update        `hcl:"update" hcle:"omitempty" `
+	Group         []GroupInfo   `hcl:"group"`
 }
 
 type Periodic struct {
-	Cron            string `hcl:"cron" hcle:"omitempty"`
-	ProhibitOverlap bool   `hcl:"prohibit_overlap" hcle:"omitempty"`
+	Cron            string   `hcl:"cron" hcle:"omitempty"`
+	Crons           []string `hcl:"crons" hcle:"omitempty"`
+	TimeZone        string   `hcl:"time_zone" hcle:"omitempty"`
+	ProhibitOverlap bool     `hcl:"prohibit_overlap" hcle:"omitempty"`
+}
+
+type Parameterized struct {
+	Payload      string   `hcl:"payload" hcle:"omitempty"`
+	MetaRequired []string `hcl:"meta_required" hcle:"omitempty"`
+	MetaOptional []string `hcl:"meta_optional" hcle:"omitempty"`
 }
 
 type Meta map[string]string
@@ -115,19 +284,57 @@ type Constraint struct {
 }
 
 type Update struct {
-	Stagger     string `hcl:"stagger" hcle:"omitempty"`
-	MaxParallel int    `hcl:"max_parallel" hcle:"omitempty"`
-	Canary      int    `hcl:"canary" hcle:"omitempty"`
-	AutoRevert  bool   `hcl:"auto_revert" hcle:"omitempty"`
+	Stagger          string `hcl:"stagger" hcle:"omitempty"`
+	MaxParallel      int    `hcl:"max_parallel" hcle:"omitempty"`
+	HealthCheck      string `hcl:"health_check" hcle:"omitempty"`
+	MinHealthyTime   string `hcl:"min_healthy_time" hcle:"omitempty"`
+	HealthyDeadline  string `hcl:"healthy_deadline" hcle:"omitempty"`
+	ProgressDeadline string `hcl:"progress_deadline" hcle:"omitempty"`
+	Canary           int    `hcl:"canary" hcle:"omitempty"`
+	AutoPromote      bool   `hcl:"auto_promote" hcle:"omitempty"`
+	AutoRevert       bool   `hcl:"auto_revert" hcle:"omitempty"`
 }
 
 type GroupInfo struct {
-	Name       string       `hcl:",key"`
-	Count      int          `hcl:"count"`
-	Update     Update       `hcl:"update" hcle:"omitempty"`
-	Constraint []Constraint `hcl:"constraint"`
-	Restart    Restart      `hcl:"restart" hcle:"omitempty"`
-	Task       []TaskInfo   `hcl:"task"`
+	Name          string         `hcl:",key"`
+	Count         *int           `hcl:"count"`
+	Update        Update         `hcl:"update" hcle:"omitempty"`
+	Constraint    []Constraint   `hcl:"constraint"`
+	Restart       Restart        `hcl:"restart" hcle:"omitempty"`
+	Reschedule    *Reschedule    `hcl:"reschedule"`
+	EphemeralDisk *EphemeralDisk `hcl:"ephemeral_disk"`
+	Migrate       *Migrate       `hcl:"migrate"`
+	Volume        []Volume       `hcl:"volume" hcle:"omitempty"`
+	Task          []TaskInfo     `hcl:"task"`
+}
+
+type Volume struct {
+	Name           string `hcl:",key"`
+	Type           string `hcl:"type"`
+	Source         string `hcl:"source"`
+	ReadOnly       bool   `hcl:"read_only" hcle:"omitempty"`
+	AccessMode     string `hcl:"access_mode" hcle:"omitempty"`
+	AttachmentMode string `hcl:"attachment_mode" hcle:"omitempty"`
+	PerAlloc       bool   `hcl:"per_alloc" hcle:"omitempty"`
+}
+
+type VolumeMount struct {
+	Volume      string `hcl:"volume"`
+	Destination string `hcl:"destination"`
+	ReadOnly    bool   `hcl:"read_only" hcle:"omitempty"`
+}
+
+type EphemeralDisk struct {
+	Size    int  `hcl:"size"`
+	Sticky  bool `hcl:"sticky" hcle:"omitempty"`
+	Migrate bool `hcl:"migrate" hcle:"omitempty"`
+}
+
+type Migrate struct {
+	MaxParallel     int    `hcl:"max_parallel"`
+	HealthCheck     string `hcl:"health_check" hcle:"omitempty"`
+	MinHealthyTime  string `hcl:"min_healthy_time" hcle:"omitempty"`
+	HealthyDeadline string `hcl:"healthy_deadline" hcle:"omitempty"`
 }
 
 type Restart struct {
@@ -137,44 +344,119 @@ type Restart struct {
 	Mode     string `hcl:"mode"`
 }
 
+type Reschedule struct {
+	Attempts      int    `hcl:"attempts"`
+	Interval      string `hcl:"interval" hcle:"omitempty"`
+	Delay         string `hcl:"delay"`
+	DelayFunction string `hcl:"delay_function"`
+	MaxDelay      string `hcl:"max_delay" hcle:"omitempty"`
+	Unlimited     bool   `hcl:"unlimited"`
+}
+
 type TaskInfo struct {
-	Name      string     `hcl:",key"`
-	Meta      Meta       `hcl:"meta"`
-	Template  []Template `hcl:"template"`
-	Driver    string     `hcl:"driver"`
-	Config    Config     `hcl:"config"`
-	Service   []Service  `hcl:"service"`
-	Vault     Vault      `hcl:"vault" hcle:"omitempty"`
-	Env       Env        `hcl:"env" hcle:"omitempty"`
-	Resources Resources  `hcl:"resources"`
+	Name        string        `hcl:",key"`
+	Meta        Meta          `hcl:"meta"`
+	Template    []Template    `hcl:"template"`
+	Artifact    []Artifact    `hcl:"artifact" hcle:"omitempty"`
+	Driver      string        `hcl:"driver"`
+	User        string        `hcl:"user" hcle:"omitempty"`
+	Lifecycle   Lifecycle     `hcl:"lifecycle" hcle:"omitempty"`
+	Restart     Restart       `hcl:"restart" hcle:"omitempty"`
+	Dispatch    Dispatch      `hcl:"dispatch_payload" hcle:"omitempty"`
+	VolumeMount []VolumeMount `hcl:"volume_mount" hcle:"omitempty"`
+	Config      Config        `hcl:"config"`
+	Service     []Service     `hcl:"service"`
+	Vault       Vault         `hcl:"vault" hcle:"omitempty"`
+	Identity    []Identity    `hcl:"identity" hcle:"omitempty"`
+	Env         Env           `hcl:"env" hcle:"omitempty"`
+	Resources   Resources     `hcl:"resources"`
 }
 
 type Template struct {
-	ChangeMode     string `hcl:"change_mode" hcle:"omitempty"`
-	ChangeSignal   string `hcl:"change_signal" hcle:"omitempty"`
-	Data           string `hcl:"data,literal" hcle:"omitempty"`
-	Destination    string `hcl:"destination" hcle:"omitempty"`
-	Env            bool   `hcl:"env" hcle:"omitempty"`
-	LeftDelimiter  string `hcl:"left_delimiter" hcle:"omitempty"`
-	Perms          string `hcl:"perms" hcle:"omitempty"`
-	RightDelimiter string `hcl:"right_delimiter" hcle:"omitempty"`
-	Source         string `hcl:"source" hcle:"omitempty"`
-	Splay          string `hcl:"splay" hcle:"omitempty"`
-	VaultGrace     string `hcl:"vault_grace" hcle:"omitempty"`
+	ChangeMode     string        `hcl:"change_mode" hcle:"omitempty"`
+	ChangeSignal   string        `hcl:"change_signal" hcle:"omitempty"`
+	ChangeScript   *ChangeScript `hcl:"change_script"`
+	Data           string        `hcl:"data,literal" hcle:"omitempty"`
+	Destination    string        `hcl:"destination" hcle:"omitempty"`
+	Env            bool          `hcl:"env" hcle:"omitempty"`
+	LeftDelimiter  string        `hcl:"left_delimiter" hcle:"omitempty"`
+	Perms          string        `hcl:"perms" hcle:"omitempty"`
+	UID            *int          `hcl:"uid"`
+	GID            *int          `hcl:"gid"`
+	RightDelimiter string        `hcl:"right_delimiter" hcle:"omitempty"`
+	Source         string        `hcl:"source" hcle:"omitempty"`
+	Splay          string        `hcl:"splay" hcle:"omitempty"`
+	VaultGrace     string        `hcl:"vault_grace" hcle:"omitempty"`
+}
+
+type ChangeScript struct {
+	Command     string   `hcl:"command"`
+	Args        []string `hcl:"args" hcle:"omitempty"`
+	Timeout     string   `hcl:"timeout" hcle:"omitempty"`
+	FailOnError bool     `hcl:"fail_on_error" hcle:"omitempty"`
+}
+
+type Dispatch struct {
+	File string `hcl:"file"`
+}
+
+type Lifecycle struct {
+	Hook    string `hcl:"hook"`
+	Sidecar bool   `hcl:"sidecar" hcle:"omitempty"`
+}
+
+type Artifact struct {
+	Source      string            `hcl:"source"`
+	Destination string            `hcl:"destination"`
+	Mode        string            `hcl:"mode" hcle:"omitempty"`
+	Options     map[string]string `hcl:"options" hcle:"omitempty"`
 }
 
 type Config struct {
 	AdvertiseIpv6Address bool              `hcl:"advertise_ipv6_address"`
 	Image                string            `hcl:"image"`
+	Entrypoint           []string          `hcl:"entrypoint" hcle:"omitempty"`
 	Command              string            `hcl:"command" hcle:"omitempty"`
+	WorkDir              string            `hcl:"work_dir" hcle:"omitempty"`
 	Hostname             string            `hcl:"hostname" hcle:"omitempty"`
 	ForcePull            bool              `hcl:"force_pull" hcle:"omitempty"`
+	Auth                 *Auth             `hcl:"auth"`
+	AuthSoftFail         bool              `hcl:"auth_soft_fail" hcle:"omitempty"`
 	Args                 []string          `hcl:"args,omitempty"`
 	Labels               map[string]string `hcl:"labels" hcle:"omitempty"`
 	Volumes              []string          `hcl:"volumes" hcle:"omitempty"`
+	Mount                []Mount           `hcl:"mount" hcle:"omitempty"`
+	CapAdd               []string          `hcl:"cap_add" hcle:"omitempty"`
+	CapDrop              []string          `hcl:"cap_drop" hcle:"omitempty"`
+	ReadonlyRootfs       bool              `hcl:"readonly_rootfs" hcle:"omitempty"`
+	Privileged           bool              `hcl:"privileged" hcle:"omitempty"`
+	Sysctl               map[string]string `hcl:"sysctl" hcle:"omitempty"`
+	Ulimit               map[string]string `hcl:"ulimit" hcle:"omitempty"`
+	ShmSize              int               `hcl:"shm_size" hcle:"omitempty"`
+	Init                 bool              `hcl:"init" hcle:"omitempty"`
+	DNSServers           []string          `hcl:"dns_servers" hcle:"omitempty"`
+	ExtraHosts           []string          `hcl:"extra_hosts" hcle:"omitempty"`
+	SecurityOpt          []string          `hcl:"security_opt" hcle:"omitempty"`
 	Logging              map[string]string `hcl:"logging"`
 }
 
+type Auth struct {
+	Username string `hcl:"username"`
+	Password string `hcl:"password"`
+}
+
+type Mount struct {
+	Type         string        `hcl:"type"`
+	Target       string        `hcl:"target"`
+	Source       string        `hcl:"source" hcle:"omitempty"`
+	ReadOnly     bool          `hcl:"readonly" hcle:"omitempty"`
+	TmpfsOptions *TmpfsOptions `hcl:"tmpfs_options"`
+}
+
+type TmpfsOptions struct {
+	Size int `hcl:"size"`
+}
+
 type Service struct {
 	Name        string   `hcl:"name"`
 	Tags        []string `hcl:"tags" hcle:"omitempty"`
@@ -212,6 +494,17 @@ type Network struct {
 
 type Vault struct {
 	Policies []string `hcl:"policies" hcle:"omitempty"`
+	Role     string   `hcl:"role" hcle:"omitempty"`
+}
+
+type Identity struct {
+	Name         string   `hcl:"name" hcle:"omitempty"`
+	Aud          []string `hcl:"aud" hcle:"omitempty"`
+	Env          bool     `hcl:"env" hcle:"omitempty"`
+	File         bool     `hcl:"file" hcle:"omitempty"`
+	TTL          string   `hcl:"ttl" hcle:"omitempty"`
+	ChangeMode   string   `hcl:"change_mode" hcle:"omitempty"`
+	ChangeSignal string   `hcl:"change_signal" hcle:"omitempty"`
 }
 
 var silent = false
@@ -229,7 +522,16 @@ func main() {
 		initBatch    = cInit.Flag("batch", "create a batch specific nomadgen.toml").Short('b').Bool()
 		cWrite       = kingpin.Command("write", "creates/overwrites a project.nomad and Jenkinsfile (if not existing) based on nomadgen.toml")
 		writeJenkins = cWrite.Flag("jenkins", "overwrite Jenkinsfile").Short('j').Bool()
+		cCheck       = kingpin.Command("check-secrets", "checks if the vault secrets used by the tasks exist (VAULT_ADDR and VAULT_TOKEN env)")
+		checkTier    = cCheck.Flag("tier", "tier to check, eg production or staging").Required().String()
+		cDispatch    = kingpin.Command("dispatch-example", "shows how to dispatch the parameterized jobs")
+		dispatchTier = cDispatch.Flag("tier", "tier to dispatch to, eg production or staging").String()
+		cNextRuns    = kingpin.Command("next-runs", "shows the next runs of the periodic jobs")
+		nextRunsN    = cNextRuns.Flag("number", "number of runs to show").Short('n').Default("5").Int()
+		cPolicies    = kingpin.Command("vault-policies", "creates vault policies (vault-policy-*.hcl) for the secrets used by each task")
+		policiesTier = cPolicies.Flag("tier", "tier of the policies, eg production or staging").Required().String()
 	)
+	kingpin.Command("lock", "resolves the images of the tasks to their digest and writes them to nomadgen.lock")
 	kingpin.Command("info", "show info about nomadgen configuration")
 	kingpin.Command("jenkins", "used by jenkins to create a project.nomad").Hidden()
 	kingpin.HelpFlag.Short('h')
@@ -251,36 +553,90 @@ func main() {
 			silent = true
 			fail_when_missing = false
 		}
-		readconfig()
-		var tj Tjob
-		// unmarshal into Tjob
-		viper.Unmarshal(&tj)
+		jobs := loadJobs()
+		tj := jobs[0]
 		if kingpin.Parse() == "jenkins" && tj.Jenkins.DisableNomadgen {
 			return
 		}
-		// convert toml to hcl
-		output := convertTomlToHcl(&tj)
-		ioutil.WriteFile("project.nomad", []byte(output), 0600)
-		fmt.Println("project.nomad written.")
-		createJenkins(parseJob(&tj), tj.Mattermost, tj.Jenkins, *writeJenkins)
+		checkJobs(jobs)
+		for i := range jobs {
+			// convert toml to hcl
+			output := convertTomlToHcl(&jobs[i])
+			f := getJobFile(&jobs[i])
+			ioutil.WriteFile(f, []byte(output), 0600)
+			fmt.Println(f + " written.")
+		}
+		createJenkins(parseProject(&tj), tj.Mattermost, tj.Jenkins, *writeJenkins)
+	case "vault-policies":
+		for _, tj := range loadJobs() {
+			createVaultPolicies(&tj, *policiesTier)
+		}
+	case "check-secrets":
+		ok := true
+		for _, tj := range loadJobs() {
+			ok = checkVaultSecrets(&tj, *checkTier) && ok
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case "lock":
+		if !writeLock(loadJobs()) {
+			os.Exit(1)
+		}
+	case "dispatch-example":
+		for _, tj := range loadJobs() {
+			if isParameterized(&tj) {
+				fmt.Println(getDispatchExample(&tj, *dispatchTier))
+			}
+		}
+	case "next-runs":
+		for _, tj := range loadJobs() {
+			p := getPeriodic(&tj)
+			if p.Cron == "" && len(p.Crons) == 0 {
+				continue
+			}
+			runs, err := getNextRuns(&tj, time.Now(), *nextRunsN)
+			if err != nil {
+				fmt.Printf("error: job %s: %s\n", parseJob(&tj), err)
+				os.Exit(1)
+			}
+			fmt.Printf("job %s:\n", parseJob(&tj))
+			for _, run := range runs {
+				fmt.Println("  " + run.Format("Mon 2006-01-02 15:04 MST"))
+			}
+		}
 	case "info":
 		// read toml
-		readconfig()
-		var tj Tjob
-		// unmarshal into Tjob
-		viper.Unmarshal(&tj)
+		jobs := loadJobs()
+		tj := jobs[0]
 		fmt.Printf("nomadgen version: %s\n", version)
+		for _, job := range jobs {
+			fmt.Printf("job %s is written to %s\n", parseJob(&job), getJobFile(&job))
+		}
+		var files []string
+		for _, job := range jobs {
+			files = append(files, getJobFile(&job))
+		}
 		if tj.Jenkins.DisableNomadgen == false {
-			fmt.Println("Jenkins will generate project.nomad on each build")
+			fmt.Printf("Jenkins will generate %s on each build\n", strings.Join(files, ", "))
 		} else {
-			fmt.Println("Jenkins will not generate project.nomad on each build.\nDo not forget to run nomadgen write manually after every nomadgen.toml change")
+			fmt.Printf("Jenkins will not generate %s on each build.\nDo not forget to run nomadgen write manually after every nomadgen.toml change\n", strings.Join(files, ", "))
 		}
 		if tj.Jenkins.AutoDeployTier != "" {
-			fmt.Printf("Jenkins will automatically submit project.nomad to tier %s\n", tj.Jenkins.AutoDeployTier)
+			if len(files) == 1 && files[0] == "project.nomad" {
+				fmt.Printf("Jenkins will automatically submit project.nomad to tier %s\n", tj.Jenkins.AutoDeployTier)
+			} else {
+				fmt.Println("error: jenkins autodeploytier only submits project.nomad, it can't be combined with [[extrajob]] tables")
+			}
 		}
 	}
 }
 
+// isTrue returns true if the optional boolean is set to true.
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
 func parseOrganization(tj *Tjob) string {
 	if tj.Organization == "" {
 		return "prefix"
@@ -288,7 +644,17 @@ func parseOrganization(tj *Tjob) string {
 	return tj.Organization
 }
 
+// parseJob returns the job name, the jobs of [[extrajob]] tables have their name as suffix.
 func parseJob(tj *Tjob) string {
+	job := parseProject(tj)
+	if tj.Suffix != "" {
+		job += "-" + tj.Suffix
+	}
+	return job
+}
+
+// parseProject returns the job name of the project, shared by all its jobs.
+func parseProject(tj *Tjob) string {
 	job := ""
 	job = parseOrganization(tj)
 	job += "-${short_tier}-"
@@ -296,6 +662,22 @@ func parseJob(tj *Tjob) string {
 	return job
 }
 
+// getJobFile returns the file the job is written to.
+func getJobFile(tj *Tjob) string {
+	if tj.Suffix != "" {
+		return "project-" + tj.Suffix + ".nomad"
+	}
+	return "project.nomad"
+}
+
+// getGroupFileName returns the name used in the files generated for a taskgroup.
+func getGroupFileName(tj *Tjob, taskgroupName string) string {
+	if tj.Suffix != "" {
+		return tj.Suffix + "-" + taskgroupName
+	}
+	return taskgroupName
+}
+
 func parseEnv(tj *Tjob, labels []string) Env {
 	lmap := make(Env)
 	for _, label := range labels {
@@ -321,8 +703,9 @@ func parseLabels(tj *Tjob, labels []string) map[string]string {
 	return lmap
 }
 
-func parseInject(tj *Tjob, inject []string) ([]Template, []string) {
+func parseInject(tj *Tjob, inject []string) ([]Template, []Artifact, []string) {
 	var templates []Template
+	var artifacts []Artifact
 	var volumes []string
 	// make map unique
 	m := make(map[string]bool)
@@ -342,34 +725,71 @@ func parseInject(tj *Tjob, inject []string) ([]Template, []string) {
 		}
 	}
 
+	inputs := []string{}
 	for input := range m {
-		splitInput := strings.Split(input, ":")
-		fileName := splitInput[0]
-		envOpt := false
-		if len(splitInput) > 1 {
-			for _, opt := range splitInput[1:] {
-				if opt == "env" {
-					envOpt = true
-				}
-				// if we have a / starting it means we want to overwrite a file in the container
-				// append this to the volumes []string with the full path
-				if strings.HasPrefix(opt, "/") {
-					volumes = append(volumes, "secrets/"+fileName+":"+opt)
-				}
-			}
-		}
-		if strings.HasSuffix(fileName, ".env") {
-			envOpt = true
-		}
-		content, err := ioutil.ReadFile(fileName)
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+
+	for _, input := range inputs {
+		entries, err := expandInjectEntry(parseInjectEntry(input))
 		if err != nil {
 			log.Println("Something went wrong:")
 			log.Println(err)
 			continue
 		}
-		templates = append(templates, Template{Data: "<<EOH\n" + string(content) + "\nEOH", Destination: "secrets/" + fileName, Env: envOpt})
+		for _, entry := range entries {
+			// append the container paths to the volumes []string with the full path
+			for _, target := range entry.Targets {
+				volumes = append(volumes, "secrets/"+entry.File+":"+target)
+			}
+			content, err := ioutil.ReadFile(entry.File)
+			if err != nil {
+				log.Println("Something went wrong:")
+				log.Println(err)
+				continue
+			}
+			if useArtifact(entry, content) {
+				artifact, err := publishArtifact(entry, content)
+				if err != nil {
+					log.Println("Something went wrong:")
+					log.Println(err)
+					continue
+				}
+				artifacts = append(artifacts, artifact)
+				continue
+			}
+			template := Template{Data: string(content), Destination: "secrets/" + entry.File, Env: entry.Env}
+			if entry.Raw {
+				template.LeftDelimiter, template.RightDelimiter, template.Data = getRawTemplate(template.Data)
+			}
+			template.Data = "<<EOH\n" + template.Data + "\nEOH"
+			templates = append(templates, template)
+		}
 	}
-	return templates, volumes
+	return templates, artifacts, volumes
+}
+
+// parseVaultEnv splits a vaultenv entry into the environment variable name and the
+// path of the secret relative to the team.
+func parseVaultEnv(tj *Tjob, e string) (string, string) {
+	fp := tj.Project + "/" + e
+	fb := filepath.Base(e)
+	if strings.Contains(e, "<-") {
+		res := strings.Split(e, "<-")
+		e = res[1]
+		fb = res[0]
+		fp = tj.Project + "/" + e
+	}
+	if strings.Contains(e, "/") {
+		fp = e
+	}
+	return fb, fp
+}
+
+// getVaultSecretPath returns the full vault path of a secret relative to the team.
+func getVaultSecretPath(tj *Tjob, path string) string {
+	return "secret/projects/prefix-${short_tier}-" + tj.Team + "/" + path
 }
 
 // createVaultEnvInject creates vault-taskname-count.env files which contain the necessary information
@@ -377,18 +797,8 @@ func parseInject(tj *Tjob, inject []string) ([]Template, []string) {
 func createVaultEnvInject(tj *Tjob, env []string, name string, count int) string {
 	content := ""
 	for _, e := range env {
-		fp := tj.Project + "/" + e
-		fb := filepath.Base(e)
-		if strings.Contains(e, "<-") {
-			res := strings.Split(e, "<-")
-			e = res[1]
-			fb = res[0]
-			fp = tj.Project + "/" + e
-		}
-		if strings.Contains(e, "/") {
-			fp = e
-		}
-		content += fb + "=\"{{with secret \"secret/projects/prefix-${short_tier}-" + tj.Team + "/" + fp + "\"}}{{.Data.value}}{{end}}\"\n"
+		fb, fp := parseVaultEnv(tj, e)
+		content += fb + "=\"{{with secret \"" + getVaultSecretPath(tj, fp) + "\"}}{{" + getVaultField("value") + "}}{{end}}\"\n"
 	}
 	// return nothing if we have no content
 	if content == "" {
@@ -408,7 +818,7 @@ func createVaultFileInject(tj *Tjob, files []string, name string, count int) []s
 	for _, entry := range files {
 		splitInput := strings.Split(entry, ":")
 		vaultKey := splitInput[0]
-		content = "{{with secret \"secret/projects/prefix-${short_tier}-" + tj.Team + "/" + tj.Project + "/" + vaultKey + "\"}}{{.Data.value}}{{end}}\n"
+		content = "{{with secret \"" + getVaultSecretPath(tj, tj.Project+"/"+vaultKey) + "\"}}{{" + getVaultField("value") + "}}{{end}}\n"
 		f := "vault-" + name + "-" + strconv.Itoa(count) + "-" + strconv.Itoa(i) + ".inj"
 		ioutil.WriteFile(f, []byte(content), 0600)
 		if len(splitInput) > 0 {
@@ -425,10 +835,47 @@ func createVaultFileInject(tj *Tjob, files []string, name string, count int) []s
 	return result
 }
 
+func getParameterized(tj *Tjob) Parameterized {
+	return Parameterized{
+		Payload:      tj.Parameterized.Payload,
+		MetaRequired: tj.Parameterized.MetaRequired,
+		MetaOptional: tj.Parameterized.MetaOptional,
+	}
+}
+
+// isParameterized returns true if the job has parameterized settings.
+func isParameterized(tj *Tjob) bool {
+	p := tj.Parameterized
+	return p.Payload != "" || len(p.MetaRequired) > 0 || len(p.MetaOptional) > 0
+}
+
+// isBatch returns true for batch and sysbatch jobs.
+func isBatch(tj *Tjob) bool {
+	return tj.Type == "batch" || tj.Type == "sysbatch"
+}
+
+// isSystem returns true for system and sysbatch jobs, which run on every node.
+func isSystem(tj *Tjob) bool {
+	return tj.Type == "system" || tj.Type == "sysbatch"
+}
+
 func getPeriodic(tj *Tjob) Periodic {
-	if tj.Type != "batch" {
+	if !isBatch(tj) {
 		return Periodic{}
 	}
+	if len(tj.Periodic.Crons) > 0 {
+		p := Periodic{
+			TimeZone:        tj.Periodic.TimeZone,
+			ProhibitOverlap: tj.Periodic.ProhibitOverlap == nil || *tj.Periodic.ProhibitOverlap,
+		}
+		// use cron for a single schedule, crons needs nomad 1.6+
+		if len(tj.Periodic.Crons) == 1 {
+			p.Cron = tj.Periodic.Crons[0]
+		} else {
+			p.Crons = tj.Periodic.Crons
+		}
+		return p
+	}
 	// return empty if we have a batch without a cron
 	if tj.Cron == "" {
 		return Periodic{}
@@ -440,23 +887,59 @@ func getPeriodic(tj *Tjob) Periodic {
 	return Periodic{Cron: cron[0], ProhibitOverlap: true}
 }
 
-func getRestart(tj *Tjob) Restart {
-	if tj.Type == "batch" {
-		return Restart{}
+func getUpdate(tj *Tjob) Update {
+	if isBatch(tj) {
+		return Update{}
 	}
-	return Restart{Interval: "1m", Attempts: 5, Delay: "10s", Mode: "delay"}
+	// system jobs don't support auto_revert
+	if isSystem(tj) {
+		return applyUpdate(Update{Stagger: "10s", MaxParallel: 1}, tj.Update)
+	}
+	return applyUpdate(Update{Stagger: "10s", MaxParallel: 1, AutoRevert: tj.AutoRevert}, tj.Update)
 }
 
-func getUpdate(tj *Tjob) Update {
-	if tj.Type == "batch" {
-		return Update{}
+// applyUpdate returns the update stanza with the configured update settings applied.
+func applyUpdate(u Update, t Tupdate) Update {
+	if t.MaxParallel != nil {
+		u.MaxParallel = *t.MaxParallel
+	}
+	if t.HealthCheck != "" {
+		u.HealthCheck = t.HealthCheck
+	}
+	if t.MinHealthyTime != "" {
+		u.MinHealthyTime = t.MinHealthyTime
 	}
-	return Update{Stagger: "10s", MaxParallel: 1, AutoRevert: tj.AutoRevert}
+	if t.HealthyDeadline != "" {
+		u.HealthyDeadline = t.HealthyDeadline
+	}
+	if t.ProgressDeadline != "" {
+		u.ProgressDeadline = t.ProgressDeadline
+	}
+	if t.AutoPromote {
+		u.AutoPromote = true
+	}
+	if t.Stagger != "" {
+		u.Stagger = t.Stagger
+	}
+	return u
 }
 
-func getVault(tj *Tjob, policies []string) Vault {
+func getVault(tj *Tjob, task Ttask) Vault {
 	v := Vault{}
-	policies = getVaultPolicies(tj, policies)
+	if site.VaultIdentity {
+		if usesVault(tj, task) {
+			v.Role = getVaultRole(tj, task)
+		}
+		return v
+	}
+	policies := getVaultPolicies(tj, task.VaultPolicies)
+	// the policy generated by vault-policies for the secrets of the task, when the site loads them in vault
+	if site.VaultPolicies && len(getVaultPathsForTask(tj, task)) > 0 {
+		policies = append(policies, getVaultPolicyName(tj, task))
+	}
+	if r, ok := getRegistry(task.Image); ok && r.VaultPath != "" && r.VaultPolicy != "" {
+		policies = append(policies, r.VaultPolicy)
+	}
 	if len(policies) == 0 {
 		return v
 	}
@@ -464,6 +947,59 @@ func getVault(tj *Tjob, policies []string) Vault {
 	return v
 }
 
+// usesVault returns true if the task needs access to vault.
+func usesVault(tj *Tjob, task Ttask) bool {
+	return len(task.VaultPolicies) > 0 || len(task.VaultEnv) > 0 || len(task.VaultInject) > 0 ||
+		task.VaultRole != "" || tj.VaultRole != "" || getRegistryVaultPath(task) != ""
+}
+
+// getVaultRole returns the vault role used with workload identities.
+// The task role overrides the job role, which defaults to the job name of the project.
+func getVaultRole(tj *Tjob, task Ttask) string {
+	if task.VaultRole != "" {
+		return task.VaultRole
+	}
+	if tj.VaultRole != "" {
+		return tj.VaultRole
+	}
+	return parseProject(tj)
+}
+
+// getIdentities returns the workload identities of a task. Task identities override
+// job identities with the same name. When vault uses workload identities a default
+// vault identity is added if none is configured.
+func getIdentities(tj *Tjob, task Ttask) []Identity {
+	var identities []Identity
+	index := make(map[string]int)
+	for _, list := range [][]Tidentity{tj.Identity, task.Identity} {
+		for _, id := range list {
+			identity := Identity{
+				Name:         id.Name,
+				Aud:          id.Aud,
+				Env:          id.Env,
+				File:         id.File,
+				TTL:          id.TTL,
+				ChangeMode:   id.ChangeMode,
+				ChangeSignal: id.ChangeSignal,
+			}
+			if i, ok := index[id.Name]; ok {
+				identities[i] = identity
+				continue
+			}
+			index[id.Name] = len(identities)
+			identities = append(identities, identity)
+		}
+	}
+	if _, ok := index["vault_default"]; site.VaultIdentity && !ok && usesVault(tj, task) {
+		identities = append(identities, Identity{
+			Name: "vault_default",
+			Aud:  site.VaultAudience,
+			TTL:  site.IdentityTTL,
+		})
+	}
+	return identities
+}
+
 func getVaultPolicies(tj *Tjob, policies []string) []string {
 	n := []string{}
 	prefix := parseOrganization(tj)
@@ -624,16 +1160,21 @@ func getDistinctDatacenter(tg *Tgroup) string {
 	if tg.Count%2 != 0 {
 		log.Fatalf("group count %s is odd: %d", tg.Name, tg.Count)
 	}
+	canary := getCanary(tg)
 	// if canary is not even, we make it even for the distinct property count
-	if tg.Canary%2 != 0 {
-		return strconv.Itoa((tg.Canary + 1 + tg.Count) / 2)
+	if canary%2 != 0 {
+		return strconv.Itoa((canary + 1 + tg.Count) / 2)
 	}
-	distinct := (tg.Count + tg.Canary) / 2
+	distinct := (tg.Count + canary) / 2
 	return strconv.Itoa(distinct)
 }
 
 func getServiceForTask(tj *Tjob, task Ttask) []Service {
 	var services []Service
+	// lifecycle tasks only get a service when they have a port or services
+	if task.Lifecycle != "" && task.Port == 0 && len(task.Service) == 0 {
+		return nil
+	}
 	if len(task.Service) > 0 {
 		for _, svc := range task.Service {
 			task := Ttask{Porttype: svc.PortType, Firewall: svc.Firewall, CheckPath: svc.CheckPath, Grace: svc.Grace, Port: svc.Port, Tags: svc.Tags, Name: svc.Name}
@@ -659,42 +1200,82 @@ func getServiceForTask(tj *Tjob, task Ttask) []Service {
 
 func getTaskForGroup(tj *Tjob, taskgroupName string) []TaskInfo {
 	ti := []TaskInfo{}
+	fileName := getGroupFileName(tj, taskgroupName)
 	for i, task := range tj.Task {
 		if task.Taskgroup == taskgroupName {
-			result := createVaultEnvInject(tj, task.VaultEnv, taskgroupName, i)
+			result := createVaultEnvInject(tj, task.VaultEnv, fileName, i)
 			if result != "" {
 				task.Inject = append(task.Inject, result)
 			}
-			results := createVaultFileInject(tj, task.VaultInject, taskgroupName, i)
+			consul := createConsulEnvInject(tj, task, fileName, i)
+			if consul != "" {
+				task.Inject = append(task.Inject, consul)
+			}
+			registry := createRegistryEnvInject(task, fileName, i)
+			if registry != "" {
+				task.Inject = append(task.Inject, registry)
+			}
+			results := createVaultFileInject(tj, task.VaultInject, fileName, i)
 			if len(result) > 0 {
 				task.Inject = append(task.Inject, results...)
 			}
 			if isemptyFirewall(tj, task) {
 				task.Port = 0
 			}
-			templates, volumes := parseInject(tj, task.Inject)
+			templates, artifacts, volumes := parseInject(tj, task.Inject)
+			templates = applyTemplateSettings(templates, task.Template)
 			if len(volumes) > 0 {
 				task.Volumes = append(task.Volumes, volumes...)
 			}
+			auth, authSoftFail := getAuth(task)
+			image, isLocked := getLockedImage(task.Image)
 			ti = append(ti, TaskInfo{
-				Name:     getTaskName(tj, task),
-				Meta:     getTaskMeta(task),
-				Driver:   "docker",
-				Template: templates,
+				Name:   getTaskName(tj, task),
+				Meta:   getTaskMeta(task),
+				Driver: "docker",
+				User:   task.User,
+				Lifecycle: Lifecycle{
+					Hook:    task.Lifecycle,
+					Sidecar: isTrue(task.Sidecar),
+				},
+				Restart: getTaskRestart(tj, task),
+				Dispatch: Dispatch{
+					File: task.DispatchPayload,
+				},
+				Template:    templates,
+				Artifact:    artifacts,
+				VolumeMount: getVolumeMounts(task),
 				Config: Config{
 					AdvertiseIpv6Address: true,
-					Image:                task.Image,
+					Image:                image,
 					Args:                 task.Args,
 					Hostname:             task.Hostname,
 					Command:              task.Command,
-					ForcePull:            !task.NoForcePull,
+					ForcePull:            !isTrue(task.NoForcePull) && !isLocked,
+					Auth:                 auth,
+					AuthSoftFail:         authSoftFail,
 					Volumes:              task.Volumes,
+					Mount:                getMounts(task),
+					Entrypoint:           task.Entrypoint,
+					WorkDir:              task.WorkDir,
+					CapAdd:               task.CapAdd,
+					CapDrop:              task.CapDrop,
+					ReadonlyRootfs:       isTrue(task.ReadonlyRootfs),
+					Privileged:           isTrue(task.Privileged),
+					Sysctl:               parseEnv(tj, task.Sysctl),
+					Ulimit:               parseEnv(tj, task.Ulimit),
+					ShmSize:              task.ShmSize,
+					Init:                 isTrue(task.Init),
+					DNSServers:           task.DNSServers,
+					ExtraHosts:           task.ExtraHosts,
+					SecurityOpt:          task.SecurityOpt,
 					Labels:               parseLabels(tj, task.Labels),
 					Logging:              map[string]string{"type": "journald"},
 				},
-				Service: getServiceForTask(tj, task),
-				Env:     getFirewallForService(tj, task),
-				Vault:   getVault(tj, task.VaultPolicies),
+				Service:  getServiceForTask(tj, task),
+				Env:      getFirewallForService(tj, task),
+				Vault:    getVault(tj, task),
+				Identity: getIdentities(tj, task),
 				Resources: Resources{
 					Memory: task.Memory,
 					CPU:    task.CPU,
@@ -711,20 +1292,37 @@ func getTaskForGroup(tj *Tjob, taskgroupName string) []TaskInfo {
 func getGroupForJob(tj *Tjob) []GroupInfo {
 	gi := []GroupInfo{}
 	for _, tg := range tj.Taskgroup {
+		// system jobs run on every node, without count, canaries or datacenter spreading
+		if isSystem(tj) {
+			gi = append(gi, GroupInfo{
+				Name: parseJob(tj) + "-" + tg.Name,
+				Constraint: []Constraint{
+					{DistinctHosts: true},
+				},
+				Restart:       getRestart(tj, &tg),
+				Update:        applyUpdate(Update{}, tg.Update),
+				EphemeralDisk: getEphemeralDisk(&tg),
+				Volume:        getVolumes(&tg),
+				Task:          getTaskForGroup(tj, tg.Name),
+			})
+			continue
+		}
+		count := tg.Count
 		gi = append(gi, GroupInfo{
 			Name:  parseJob(tj) + "-" + tg.Name,
-			Count: tg.Count,
+			Count: &count,
 			Constraint: []Constraint{
 				{DistinctHosts: true},
 				{DistinctProperty: "${meta.datacenter}",
 					Value: getDistinctDatacenter(&tg)},
 			},
-			Restart: getRestart(tj),
-			Update: Update{
-				Canary:     tg.Canary,
-				AutoRevert: tg.AutoRevert,
-			},
-			Task: getTaskForGroup(tj, tg.Name),
+			Restart:       getRestart(tj, &tg),
+			Reschedule:    getReschedule(tj, &tg),
+			Update:        getGroupUpdate(&tg, Update{}),
+			EphemeralDisk: getEphemeralDisk(&tg),
+			Migrate:       getMigrate(&tg),
+			Volume:        getVolumes(&tg),
+			Task:          getTaskForGroup(tj, tg.Name),
 		})
 	}
 	return gi
@@ -733,11 +1331,12 @@ func getGroupForJob(tj *Tjob) []GroupInfo {
 func convertTomlToHcl(tj *Tjob) string {
 	ji := []JobInfo{}
 	ji = append(ji, JobInfo{
-		Name:        parseJob(tj),
-		Type:        tj.Type,
-		Periodic:    getPeriodic(tj),
-		Datacenters: []string{datacenter},
-		Meta:        Meta{"contact": tj.Contact},
+		Name:          parseJob(tj),
+		Type:          tj.Type,
+		Periodic:      getPeriodic(tj),
+		Parameterized: getParameterized(tj),
+		Datacenters:   []string{datacenter},
+		Meta:          Meta{"contact": tj.Contact},
 		Constraint: []Constraint{
 			{Attribute: "${meta.role}",
 				Value: metarole},
@@ -753,6 +1352,52 @@ func convertTomlToHcl(tj *Tjob) string {
 	return string(res)
 }
 
+// loadJobs reads the toml config and returns the resolved jobs.
+func loadJobs() []Tjob {
+	readconfig()
+	var tj Tjob
+	// unmarshal into Tjob
+	viper.Unmarshal(&tj)
+	readfragments(&tj)
+	jobs := splitJobs(&tj)
+	for i := range jobs {
+		resolveJob(&jobs[i])
+	}
+	return jobs
+}
+
+// splitJobs returns the jobs of the project: the main job, unless it only has [[extrajob]] tables,
+// and a job for every [[extrajob]] table, which inherits the other settings of the main job.
+func splitJobs(tj *Tjob) []Tjob {
+	var jobs []Tjob
+	if len(tj.Taskgroup) > 0 || len(tj.ExtraJob) == 0 {
+		job := *tj
+		job.ExtraJob = nil
+		jobs = append(jobs, job)
+	}
+	for _, sub := range tj.ExtraJob {
+		job := *tj
+		job.ExtraJob = nil
+		job.Suffix = sub.Name
+		job.Type = sub.Type
+		job.Cron = sub.Cron
+		job.Periodic = sub.Periodic
+		job.AutoRevert = sub.AutoRevert
+		job.Parameterized = sub.Parameterized
+		job.Update = sub.Update
+		job.Taskgroup = sub.Taskgroup
+		job.Task = sub.Task
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// resolveJob expands the job config before it is validated and converted.
+func resolveJob(tj *Tjob) {
+	resolveExtends(tj)
+	expandSidecars(tj)
+}
+
 func readconfig() {
 	viper.AutomaticEnv()
 	viper.SetEnvPrefix("nomadgen")
@@ -778,4 +1423,6 @@ func readconfig() {
 	if !strings.HasSuffix(viper.ConfigFileUsed(), ".toml") {
 		fmt.Fprintln(os.Stderr, "Only toml is officially suported. Contact jo vandeginste for problems with other input formats.")
 	}
+	readsite()
+	readlock()
 }
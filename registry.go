@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// environment variables the registry credentials are injected in
+const (
+	registryUsernameEnv = "NOMADGEN_REGISTRY_USERNAME"
+	registryPasswordEnv = "NOMADGEN_REGISTRY_PASSWORD"
+)
+
+// getRegistryHost returns the registry host of a docker image, docker.io when the image
+// doesn't start with a registry.
+func getRegistryHost(image string) string {
+	host, _ := splitRegistry(image)
+	return host
+}
+
+// getRegistry returns the site settings of the registry of the image.
+func getRegistry(image string) (Tregistry, bool) {
+	host := getRegistryHost(image)
+	for _, r := range site.Registry {
+		if strings.ToLower(r.Host) == host {
+			return r, true
+		}
+	}
+	return Tregistry{}, false
+}
+
+// createRegistryEnvInject creates registry-taskname-count.env files with the credentials of the
+// registry of the task image in vault. It returns the created filename.
+func createRegistryEnvInject(task Ttask, name string, count int) string {
+	r, ok := getRegistry(task.Image)
+	if !ok || r.VaultPath == "" {
+		return ""
+	}
+	content := registryUsernameEnv + "=\"{{with secret \"" + r.VaultPath + "\"}}{{" + getVaultField("username") + "}}{{end}}\"\n"
+	content += registryPasswordEnv + "=\"{{with secret \"" + r.VaultPath + "\"}}{{" + getVaultField("password") + "}}{{end}}\"\n"
+	f := "registry-" + name + "-" + strconv.Itoa(count) + ".env"
+	ioutil.WriteFile(f, []byte(content), 0600)
+	return f
+}
+
+// getAuth returns the docker auth of the task, using the credentials injected by
+// createRegistryEnvInject, and if the task may start when the authentication fails.
+func getAuth(task Ttask) (*Auth, bool) {
+	r, ok := getRegistry(task.Image)
+	if !ok || r.VaultPath == "" {
+		return nil, r.SoftFail
+	}
+	return &Auth{
+		Username: "${" + registryUsernameEnv + "}",
+		Password: "${" + registryPasswordEnv + "}",
+	}, r.SoftFail
+}
+
+// getRegistryVaultPath returns the vault path of the credentials of the registry of the task image.
+func getRegistryVaultPath(task Ttask) string {
+	r, _ := getRegistry(task.Image)
+	return r.VaultPath
+}
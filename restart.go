@@ -0,0 +1,87 @@
+package main
+
+// getDefaultRestart returns the restart policy used for the job type.
+func getDefaultRestart(tj *Tjob) Restart {
+	if isBatch(tj) {
+		return Restart{Interval: "24h", Attempts: 3, Delay: "15s", Mode: "fail"}
+	}
+	return Restart{Interval: "1m", Attempts: 5, Delay: "10s", Mode: "delay"}
+}
+
+// getRestart returns the restart policy of the taskgroup, batch jobs use the nomad
+// defaults unless configured.
+func getRestart(tj *Tjob, tg *Tgroup) Restart {
+	if isBatch(tj) && tg.Restart == (Trestart{}) {
+		return Restart{}
+	}
+	return applyRestart(getDefaultRestart(tj), tg.Restart)
+}
+
+// getTaskRestart returns the restart policy of a task overriding the one of its taskgroup,
+// or an empty policy when the task uses the taskgroup policy.
+func getTaskRestart(tj *Tjob, task Ttask) Restart {
+	if task.Restart == (Trestart{}) {
+		return Restart{}
+	}
+	r := getDefaultRestart(tj)
+	for _, tg := range tj.Taskgroup {
+		if tg.Name == task.Taskgroup {
+			r = applyRestart(r, tg.Restart)
+		}
+	}
+	return applyRestart(r, task.Restart)
+}
+
+func applyRestart(r Restart, t Trestart) Restart {
+	if t.Attempts != nil {
+		r.Attempts = *t.Attempts
+	}
+	if t.Interval != "" {
+		r.Interval = t.Interval
+	}
+	if t.Delay != "" {
+		r.Delay = t.Delay
+	}
+	if t.Mode != "" {
+		r.Mode = t.Mode
+	}
+	return r
+}
+
+// getReschedule returns the reschedule policy of the taskgroup, starting from the nomad
+// defaults of the job type. It returns nil when there is no [taskgroup.reschedule].
+func getReschedule(tj *Tjob, tg *Tgroup) *Reschedule {
+	t := tg.Reschedule
+	if t == (Treschedule{}) {
+		return nil
+	}
+	r := &Reschedule{Delay: "30s", DelayFunction: "exponential", MaxDelay: "1h", Unlimited: true}
+	if isBatch(tj) {
+		r = &Reschedule{Attempts: 1, Interval: "24h", Delay: "5s", DelayFunction: "constant"}
+	}
+	if t.Attempts != nil {
+		r.Attempts = *t.Attempts
+		// attempts limits the reschedules of the unlimited default
+		r.Unlimited = false
+	}
+	if t.Interval != "" {
+		r.Interval = t.Interval
+	}
+	if t.Delay != "" {
+		r.Delay = t.Delay
+	}
+	if t.DelayFunction != "" {
+		r.DelayFunction = t.DelayFunction
+	}
+	if t.MaxDelay != "" {
+		r.MaxDelay = t.MaxDelay
+	}
+	if t.Unlimited != nil {
+		r.Unlimited = *t.Unlimited
+	}
+	// a limited number of attempts needs an interval
+	if !r.Unlimited && r.Interval == "" {
+		r.Interval = "1h"
+	}
+	return r
+}
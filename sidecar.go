@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// parseSidecar splits a sidecars entry as name:version into the preset name and the version.
+func parseSidecar(entry string) (string, string) {
+	res := strings.SplitN(entry, ":", 2)
+	// site keys are lowercase
+	name := strings.ToLower(res[0])
+	if len(res) == 1 {
+		return name, ""
+	}
+	return name, res[1]
+}
+
+func hasSidecar(name string) bool {
+	_, ok := site.Sidecar[name]
+	return ok
+}
+
+// getMainTask returns the first task without lifecycle of a taskgroup.
+func getMainTask(tj *Tjob, taskgroupName string) (Ttask, bool) {
+	for _, task := range tj.Task {
+		if task.Taskgroup == taskgroupName && task.Lifecycle == "" {
+			return task, true
+		}
+	}
+	return Ttask{}, false
+}
+
+// expandSidecars adds the tasks of the sidecar presets of each taskgroup to the job.
+// Unknown presets are skipped and reported by validateJob.
+func expandSidecars(tj *Tjob) {
+	for _, tg := range tj.Taskgroup {
+		mainTask, _ := getMainTask(tj, tg.Name)
+		for _, entry := range tg.Sidecars {
+			name, version := parseSidecar(entry)
+			preset, ok := site.Sidecar[name]
+			if !ok {
+				continue
+			}
+			if version == "" {
+				version = preset.Version
+			}
+			r := strings.NewReplacer(
+				"{version}", version,
+				"{port}", strconv.Itoa(mainTask.Port),
+				"{task}", getTaskName(tj, mainTask),
+			)
+			task := preset.Ttask
+			task.Taskgroup = tg.Name
+			if task.Name == "" {
+				task.Name = name
+			}
+			task.Image = r.Replace(task.Image)
+			task.Command = r.Replace(task.Command)
+			task.Args = replaceAll(r, task.Args)
+			task.Env = replaceAll(r, task.Env)
+			task.Labels = replaceAll(r, task.Labels)
+			task.Volumes = replaceAll(r, task.Volumes)
+			task.Inject = replaceAll(r, task.Inject)
+			tj.Task = append(tj.Task, task)
+		}
+	}
+}
+
+// replaceAll returns a copy of list with the replacer applied to each entry.
+func replaceAll(r *strings.Replacer, list []string) []string {
+	if list == nil {
+		return nil
+	}
+	res := make([]string, len(list))
+	for i, s := range list {
+		res[i] = r.Replace(s)
+	}
+	return res
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// site defaults, shared by all projects of the site
+type Tsite struct {
+	// use workload identities (nomad 1.7+) for vault instead of policies
+	VaultIdentity bool
+	// audience of the default vault identity
+	VaultAudience []string
+	// ttl of the default vault identity
+	IdentityTTL string
+	// reference the policies created by vault-policies in the vault stanza of the tasks
+	VaultPolicies bool
+	// version of the vault kv secrets engine the secrets are stored in, 1 or 2
+	VaultKVVersion int
+	// inject files larger than this size (in bytes) are published as artifacts
+	InjectMaxSize int
+	// local directory where inject files are published as artifacts
+	ArtifactDir string
+	// url where the artifact directory is served
+	ArtifactURL string
+	// maximum ephemeral disk in MB of all the allocations of a job, 0 is unlimited
+	MaxDiskMB int
+	// docker options teams are allowed to use
+	Docker Tdocker
+	// credentials of private docker registries
+	Registry []Tregistry
+	// sidecar presets used by the sidecars option of a taskgroup
+	Sidecar map[string]Tsidecar
+}
+
+// a sidecar preset is a task with a default version
+// {version}, {port} and {task} are replaced by the version and the port and name of the main task
+type Tsidecar struct {
+	Ttask   `mapstructure:",squash"`
+	Version string
+}
+
+// allow-lists of docker options, options that are not listed can't be used
+// the entries are glob patterns, eg net.ipv4.* for sysctl
+type Tdocker struct {
+	// capabilities for cap_add
+	CapAdd []string
+	// sysctl keys
+	Sysctl []string
+	// ulimit names
+	Ulimit []string
+	// security_opt values
+	SecurityOpt []string
+	// projects (team/project) approved to run privileged containers
+	Privileged []string
+}
+
+// a private docker registry, used by the images starting with its host
+type Tregistry struct {
+	Host string
+	// vault path of the secret with the username and password keys
+	VaultPath string
+	// vault policy with read access on the vault path, used without workload identities
+	VaultPolicy string
+	// start the task when pulling with the credentials fails (falling back to the docker config of the host)
+	SoftFail bool
+	// use http instead of https to talk to the registry (nomadgen lock)
+	Insecure bool
+}
+
+var site Tsite
+
+// readsite reads the site defaults from nomadgen-site.toml in /etc/nomadgen or ~/.nomadgen,
+// or from the file in the NOMADGEN_SITE env. A missing site file keeps the builtin defaults.
+func readsite() {
+	s := viper.New()
+	s.SetConfigName("nomadgen-site")
+	s.AddConfigPath("/etc/nomadgen")
+	s.AddConfigPath("$HOME/.nomadgen")
+	if f := os.Getenv("NOMADGEN_SITE"); f != "" {
+		s.SetConfigFile(f)
+	}
+	err := s.ReadInConfig()
+	if err == nil {
+		s.Unmarshal(&site)
+	} else if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
+		fmt.Printf("error: site config file: %s\n", err)
+		os.Exit(1)
+	}
+	// builtin defaults
+	if len(site.VaultAudience) == 0 {
+		site.VaultAudience = []string{"vault.io"}
+	}
+	if site.IdentityTTL == "" {
+		site.IdentityTTL = "1h"
+	}
+	switch site.VaultKVVersion {
+	case 0:
+		site.VaultKVVersion = 1
+	case 1, 2:
+	default:
+		fmt.Printf("error: site config file: vaultkvversion must be 1 or 2, not %d\n", site.VaultKVVersion)
+		os.Exit(1)
+	}
+	if site.InjectMaxSize == 0 {
+		site.InjectMaxSize = 65536
+	}
+	if site.ArtifactDir == "" {
+		site.ArtifactDir = "artifacts"
+	}
+}
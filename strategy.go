@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseStrategy splits a taskgroup strategy in its name and number: blue-green,
+// canary:N (N canaries) or rolling:N (N allocations updated at a time).
+func parseStrategy(s string) (string, int, error) {
+	if s == "" || s == "blue-green" {
+		return s, 0, nil
+	}
+	res := strings.SplitN(s, ":", 2)
+	switch res[0] {
+	case "canary", "rolling":
+	default:
+		return "", 0, fmt.Errorf("unknown strategy %s, use blue-green, canary:N or rolling:N", s)
+	}
+	if len(res) != 2 {
+		return "", 0, fmt.Errorf("strategy %s: expected %s:N", s, res[0])
+	}
+	n, err := strconv.Atoi(res[1])
+	if err != nil || n < 1 {
+		return "", 0, fmt.Errorf("strategy %s: %s is not a positive number", s, res[1])
+	}
+	return res[0], n, nil
+}
+
+// getCanary returns the number of canaries of the taskgroup, blue-green deploys
+// a canary for every allocation.
+func getCanary(tg *Tgroup) int {
+	name, n, _ := parseStrategy(tg.Strategy)
+	switch name {
+	case "blue-green":
+		return tg.Count
+	case "canary":
+		return n
+	}
+	return tg.Canary
+}
+
+// getGroupUpdate returns the update stanza u of the taskgroup with its strategy applied.
+// The [taskgroup.update] settings override the strategy.
+func getGroupUpdate(tg *Tgroup, u Update) Update {
+	u.Canary = getCanary(tg)
+	u.AutoRevert = tg.AutoRevert
+	name, n, _ := parseStrategy(tg.Strategy)
+	switch name {
+	case "blue-green":
+		// promote the new set when it is healthy, go back to the old one otherwise
+		u.MaxParallel = tg.Count
+		u.AutoPromote = true
+		u.AutoRevert = true
+	case "canary":
+		u.AutoRevert = true
+	case "rolling":
+		u.MaxParallel = n
+		u.AutoRevert = true
+	}
+	return applyUpdate(u, tg.Update)
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var permsRegexp = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+var ulimitRegexp = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+
+// checkJobs validates the jobs and exits when they have errors.
+func checkJobs(jobs []Tjob) {
+	errs := validateJobs(jobs)
+	for i := range jobs {
+		errs = append(errs, validateJob(&jobs[i])...)
+	}
+	for _, err := range errs {
+		fmt.Printf("error: %s\n", err)
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
+
+// validateJobs checks the [[extrajob]] tables of the project.
+func validateJobs(jobs []Tjob) []error {
+	var errs []error
+	names := make(map[string]bool)
+	for i, job := range jobs {
+		// only the main job is the first job without a name
+		if i > 0 && job.Suffix == "" {
+			errs = append(errs, fmt.Errorf("extrajob: every [[extrajob]] needs a name"))
+			continue
+		}
+		if names[job.Suffix] {
+			errs = append(errs, fmt.Errorf("extrajob %s: duplicate job", job.Suffix))
+		}
+		names[job.Suffix] = true
+	}
+	// the Jenkinsfile only submits project.nomad
+	if len(jobs) > 0 && jobs[0].Jenkins.AutoDeployTier != "" && (len(jobs) > 1 || jobs[0].Suffix != "") {
+		errs = append(errs, errors.New("jenkins: autodeploytier only submits project.nomad, it can't be combined with [[extrajob]] tables"))
+	}
+	return errs
+}
+
+// validateJob checks the job for configuration errors and returns them.
+func validateJob(tj *Tjob) []error {
+	var errs []error
+	for _, id := range tj.Identity {
+		errs = append(errs, validateIdentity(id)...)
+	}
+	errs = append(errs, validateParameterized(tj)...)
+	errs = append(errs, validateType(tj)...)
+	errs = append(errs, validatePeriodic(tj)...)
+	errs = append(errs, validateUpdate(tj)...)
+	if total := getDiskTotal(tj); site.MaxDiskMB != 0 && total > site.MaxDiskMB {
+		errs = append(errs, fmt.Errorf("ephemeral disk of all allocations is %d MB, more than the site maximum of %d MB", total, site.MaxDiskMB))
+	}
+	for _, tg := range tj.Taskgroup {
+		for _, entry := range tg.Sidecars {
+			if name, _ := parseSidecar(entry); !hasSidecar(name) {
+				errs = append(errs, fmt.Errorf("group %s: unknown sidecar preset %s", tg.Name, name))
+			}
+		}
+		names := make(map[string]bool)
+		for _, task := range tj.Task {
+			if task.Taskgroup != tg.Name {
+				continue
+			}
+			if names[task.Name] {
+				errs = append(errs, fmt.Errorf("group %s: duplicate task %s", tg.Name, getTaskName(tj, task)))
+			}
+			names[task.Name] = true
+		}
+		if _, ok := getMainTask(tj, tg.Name); !ok {
+			errs = append(errs, fmt.Errorf("group %s: needs at least one main task (without lifecycle)", tg.Name))
+		}
+		for _, err := range validateRestart(getRestart(tj, &tg)) {
+			errs = append(errs, fmt.Errorf("group %s: %s", tg.Name, err))
+		}
+		for _, err := range validateReschedule(tj, &tg) {
+			errs = append(errs, fmt.Errorf("group %s: %s", tg.Name, err))
+		}
+		for _, err := range validateDisk(tj, &tg) {
+			errs = append(errs, fmt.Errorf("group %s: %s", tg.Name, err))
+		}
+		volumes := make(map[string]bool)
+		for _, v := range tg.Volume {
+			if volumes[v.Name] {
+				errs = append(errs, fmt.Errorf("group %s: duplicate volume %s", tg.Name, v.Name))
+			}
+			volumes[v.Name] = true
+			for _, err := range validateVolume(v) {
+				errs = append(errs, fmt.Errorf("group %s: %s", tg.Name, err))
+			}
+		}
+		for _, task := range tj.Task {
+			if task.Taskgroup != tg.Name {
+				continue
+			}
+			for _, m := range task.VolumeMount {
+				if !volumes[m.Volume] {
+					errs = append(errs, fmt.Errorf("task %s: volumemount of unknown volume %s", getTaskName(tj, task), m.Volume))
+				}
+				if !strings.HasPrefix(m.Destination, "/") {
+					errs = append(errs, fmt.Errorf("task %s: volumemount %s: destination %s is not an absolute path", getTaskName(tj, task), m.Volume, m.Destination))
+				}
+			}
+		}
+	}
+	for _, profile := range tj.Profile {
+		if _, err := resolveTask(tj, profile, []string{"profile " + profile.Name}); err != nil {
+			errs = append(errs, fmt.Errorf("profile %s: %s", profile.Name, err))
+		}
+	}
+	for _, task := range tj.Task {
+		name := getTaskName(tj, task)
+		if _, err := resolveTask(tj, task, []string{"task " + task.Name}); err != nil {
+			errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+		}
+		for _, err := range validateLifecycle(task) {
+			errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+		}
+		for _, err := range validateRestart(getTaskRestart(tj, task)) {
+			errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+		}
+		for _, v := range task.Volumes {
+			if err := validateVolumeString(v); err != nil {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+		for _, m := range task.Mount {
+			for _, err := range validateMount(m) {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+		for _, err := range validateDocker(tj, task) {
+			errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+		}
+		for _, input := range task.Inject {
+			for _, err := range validateInject(input) {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+		for _, e := range task.Discover {
+			if _, _, err := parseDiscover(tj, e); err != nil {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+		for _, ts := range task.Template {
+			for _, err := range validateTemplateSettings(ts) {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+		for _, id := range task.Identity {
+			for _, err := range validateIdentity(id) {
+				errs = append(errs, fmt.Errorf("task %s: %s", name, err))
+			}
+		}
+	}
+	return errs
+}
+
+func validateType(tj *Tjob) []error {
+	var errs []error
+	switch tj.Type {
+	case "", "service", "batch":
+	case "system", "sysbatch":
+		if tj.AutoRevert {
+			errs = append(errs, fmt.Errorf("type %s: autorevert is not supported", tj.Type))
+		}
+		for _, tg := range tj.Taskgroup {
+			if tg.Count != 0 || tg.Canary != 0 || tg.AutoRevert || tg.Strategy != "" {
+				errs = append(errs, fmt.Errorf("group %s: count, canary, strategy and autorevert are not supported for type %s", tg.Name, tj.Type))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown type %s, use service, batch, system or sysbatch", tj.Type))
+	}
+	return errs
+}
+
+func validateUpdate(tj *Tjob) []error {
+	var errs []error
+	if isBatch(tj) {
+		if !reflect.DeepEqual(tj.Update, Tupdate{}) {
+			errs = append(errs, fmt.Errorf("update: not supported for type %s", tj.Type))
+		}
+		for _, tg := range tj.Taskgroup {
+			if !reflect.DeepEqual(tg.Update, Tupdate{}) || tg.Strategy != "" {
+				errs = append(errs, fmt.Errorf("group %s: update and strategy are not supported for type %s", tg.Name, tj.Type))
+			}
+		}
+		return errs
+	}
+	errs = append(errs, validateUpdateSettings("update", tj.Update)...)
+	for _, tg := range tj.Taskgroup {
+		name := "group " + tg.Name + ": update"
+		errs = append(errs, validateUpdateSettings(name, tg.Update)...)
+		errs = append(errs, validateStrategy(&tg)...)
+		// the group inherits the job settings, use the nomad defaults for the others
+		u := getGroupUpdate(&tg, applyUpdate(Update{MinHealthyTime: "10s", HealthyDeadline: "5m", ProgressDeadline: "10m"}, tj.Update))
+		if u.AutoPromote && u.Canary == 0 {
+			errs = append(errs, fmt.Errorf("%s: autopromote needs canary", name))
+		}
+		if isSystem(tj) && (u.AutoPromote || u.ProgressDeadline != "10m") {
+			errs = append(errs, fmt.Errorf("%s: autopromote and progressdeadline are not supported for type %s", name, tj.Type))
+		}
+		min, err1 := time.ParseDuration(u.MinHealthyTime)
+		healthy, err2 := time.ParseDuration(u.HealthyDeadline)
+		progress, err3 := time.ParseDuration(u.ProgressDeadline)
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+		if min >= healthy {
+			errs = append(errs, fmt.Errorf("%s: minhealthytime %s must be less than healthydeadline %s", name, u.MinHealthyTime, u.HealthyDeadline))
+		}
+		// a progress deadline of 0 disables it
+		if progress != 0 && healthy >= progress {
+			errs = append(errs, fmt.Errorf("%s: healthydeadline %s must be less than progressdeadline %s", name, u.HealthyDeadline, u.ProgressDeadline))
+		}
+	}
+	return errs
+}
+
+func validateStrategy(tg *Tgroup) []error {
+	var errs []error
+	name, n, err := parseStrategy(tg.Strategy)
+	if err != nil {
+		return []error{fmt.Errorf("group %s: %s", tg.Name, err)}
+	}
+	if name != "" && tg.Canary != 0 {
+		errs = append(errs, fmt.Errorf("group %s: canary can't be combined with strategy %s", tg.Name, tg.Strategy))
+	}
+	switch name {
+	case "blue-green":
+		if tg.Count == 0 {
+			errs = append(errs, fmt.Errorf("group %s: strategy blue-green needs a count", tg.Name))
+		}
+	case "canary", "rolling":
+		if tg.Count != 0 && n > tg.Count {
+			errs = append(errs, fmt.Errorf("group %s: strategy %s is larger than count %d", tg.Name, tg.Strategy, tg.Count))
+		}
+	}
+	return errs
+}
+
+func validateUpdateSettings(name string, t Tupdate) []error {
+	var errs []error
+	switch t.HealthCheck {
+	case "", "checks", "task_states", "manual":
+	default:
+		errs = append(errs, fmt.Errorf("%s: unknown healthcheck %s, use checks, task_states or manual", name, t.HealthCheck))
+	}
+	if t.MaxParallel != nil && *t.MaxParallel < 0 {
+		errs = append(errs, fmt.Errorf("%s: maxparallel can't be negative", name))
+	}
+	for key, d := range map[string]string{
+		"minhealthytime":   t.MinHealthyTime,
+		"healthydeadline":  t.HealthyDeadline,
+		"progressdeadline": t.ProgressDeadline,
+		"stagger":          t.Stagger,
+	} {
+		if _, err := time.ParseDuration(d); d != "" && err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s %s is not a duration", name, key, d))
+		}
+	}
+	return errs
+}
+
+func validatePeriodic(tj *Tjob) []error {
+	var errs []error
+	if len(tj.Periodic.Crons) == 0 && tj.Periodic.TimeZone == "" && tj.Periodic.ProhibitOverlap == nil {
+		if tj.Cron != "" && isBatch(tj) {
+			if err := checkCron(strings.Split(tj.Cron, ":")[0], time.Now()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errs
+	}
+	if !isBatch(tj) {
+		errs = append(errs, fmt.Errorf("periodic: only batch and sysbatch jobs can be periodic"))
+	}
+	if tj.Cron != "" {
+		errs = append(errs, fmt.Errorf("periodic: use either cron or the periodic table"))
+	}
+	if len(tj.Periodic.Crons) == 0 {
+		errs = append(errs, fmt.Errorf("periodic: needs crons"))
+	}
+	for _, cron := range tj.Periodic.Crons {
+		if err := checkCron(cron, time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("periodic: %s", err))
+		}
+	}
+	if tj.Periodic.TimeZone != "" {
+		if _, err := time.LoadLocation(tj.Periodic.TimeZone); err != nil {
+			errs = append(errs, fmt.Errorf("periodic: timezone %s: %s", tj.Periodic.TimeZone, err))
+		}
+	}
+	return errs
+}
+
+func validateParameterized(tj *Tjob) []error {
+	var errs []error
+	switch tj.Parameterized.Payload {
+	case "", "optional", "required", "forbidden":
+	default:
+		errs = append(errs, fmt.Errorf("parameterized: unknown payload %s, use optional, required or forbidden", tj.Parameterized.Payload))
+	}
+	for _, task := range tj.Task {
+		if task.DispatchPayload == "" {
+			continue
+		}
+		if !isParameterized(tj) || tj.Parameterized.Payload == "forbidden" {
+			errs = append(errs, fmt.Errorf("task %s: dispatchpayload needs a parameterized job with a payload", getTaskName(tj, task)))
+		}
+	}
+	if !isParameterized(tj) {
+		return errs
+	}
+	if !isBatch(tj) {
+		errs = append(errs, fmt.Errorf("parameterized: only batch and sysbatch jobs can be parameterized"))
+	}
+	if p := getPeriodic(tj); p.Cron != "" || len(p.Crons) > 0 {
+		errs = append(errs, fmt.Errorf("parameterized: a parameterized job can't be periodic"))
+	}
+	return errs
+}
+
+func validateLifecycle(task Ttask) []error {
+	var errs []error
+	switch task.Lifecycle {
+	case "":
+		if isTrue(task.Sidecar) {
+			errs = append(errs, fmt.Errorf("sidecar needs a lifecycle"))
+		}
+	case "prestart":
+		if !isTrue(task.Sidecar) && (task.Port != 0 || len(task.Service) > 0) {
+			errs = append(errs, fmt.Errorf("prestart task can't declare services"))
+		}
+	case "poststart", "poststop":
+	default:
+		errs = append(errs, fmt.Errorf("unknown lifecycle %s, use prestart, poststart or poststop", task.Lifecycle))
+	}
+	return errs
+}
+
+func validateInject(input string) []error {
+	var errs []error
+	entry := parseInjectEntry(input)
+	if entry.Raw && entry.Template {
+		errs = append(errs, fmt.Errorf("inject %s: raw and template options conflict", input))
+	}
+	entries, err := expandInjectEntry(entry)
+	if err != nil {
+		return append(errs, fmt.Errorf("inject %s: %s", input, err))
+	}
+	for _, e := range entries {
+		content, err := ioutil.ReadFile(e.File)
+		if err != nil {
+			continue
+		}
+		if useArtifact(e, content) {
+			if e.Env || e.Raw || e.Template {
+				errs = append(errs, fmt.Errorf("inject %s: %s is injected as artifact and can't be used with env, raw or template", input, e.File))
+			}
+			if site.ArtifactURL == "" {
+				errs = append(errs, fmt.Errorf("inject %s: %s is injected as artifact but no artifacturl is configured in the site defaults", input, e.File))
+			}
+			if !e.Artifact {
+				log.Printf("warning: inject %s is too large or not valid UTF-8 and is published to %s, use :artifact to publish it explicitly", e.File, site.ArtifactURL)
+			}
+			continue
+		}
+		if !utf8.Valid(content) {
+			log.Printf("warning: inject %s is not valid UTF-8 and is inlined in a template, configure artifacturl in the site defaults to publish it as artifact", e.File)
+		}
+		if !e.Raw && !e.Template && strings.Contains(string(content), "{{") {
+			log.Printf("warning: inject %s contains {{ and will be rendered by consul-template, use :raw or :template", e.File)
+		}
+	}
+	return errs
+}
+
+func validateRestart(r Restart) []error {
+	var errs []error
+	if r == (Restart{}) {
+		return nil
+	}
+	switch r.Mode {
+	case "delay", "fail":
+	default:
+		errs = append(errs, fmt.Errorf("restart: unknown mode %s, use delay or fail", r.Mode))
+	}
+	if r.Attempts < 0 {
+		errs = append(errs, errors.New("restart: attempts can't be negative"))
+	}
+	interval, err := time.ParseDuration(r.Interval)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("restart: interval %s is not a duration", r.Interval))
+	}
+	delay, err2 := time.ParseDuration(r.Delay)
+	if err2 != nil {
+		errs = append(errs, fmt.Errorf("restart: delay %s is not a duration", r.Delay))
+	}
+	if err == nil && err2 == nil && time.Duration(r.Attempts)*delay > interval {
+		errs = append(errs, fmt.Errorf("restart: %d attempts with a delay of %s don't fit in an interval of %s", r.Attempts, r.Delay, r.Interval))
+	}
+	return errs
+}
+
+func validateReschedule(tj *Tjob, tg *Tgroup) []error {
+	var errs []error
+	r := getReschedule(tj, tg)
+	if r == nil {
+		return nil
+	}
+	if isSystem(tj) {
+		return []error{fmt.Errorf("reschedule: not supported for type %s", tj.Type)}
+	}
+	switch r.DelayFunction {
+	case "constant", "exponential", "fibonacci":
+	default:
+		errs = append(errs, fmt.Errorf("reschedule: unknown delayfunction %s, use constant, exponential or fibonacci", r.DelayFunction))
+	}
+	if isTrue(tg.Reschedule.Unlimited) && tg.Reschedule.Attempts != nil {
+		errs = append(errs, errors.New("reschedule: attempts can't be combined with unlimited"))
+	}
+	if r.Attempts < 0 {
+		errs = append(errs, errors.New("reschedule: attempts can't be negative"))
+	}
+	for key, d := range map[string]string{
+		"interval": r.Interval,
+		"delay":    r.Delay,
+		"maxdelay": r.MaxDelay,
+	} {
+		if _, err := time.ParseDuration(d); d != "" && err != nil {
+			errs = append(errs, fmt.Errorf("reschedule: %s %s is not a duration", key, d))
+		}
+	}
+	delay, err := time.ParseDuration(r.Delay)
+	maxDelay, err2 := time.ParseDuration(r.MaxDelay)
+	if r.DelayFunction != "constant" && err == nil && err2 == nil && maxDelay < delay {
+		errs = append(errs, fmt.Errorf("reschedule: maxdelay %s must not be less than delay %s", r.MaxDelay, r.Delay))
+	}
+	return errs
+}
+
+func validateDisk(tj *Tjob, tg *Tgroup) []error {
+	var errs []error
+	if tg.DiskMB < 0 {
+		errs = append(errs, errors.New("diskmb can't be negative"))
+	}
+	if tg.MigrateDisk && !tg.StickyDisk {
+		errs = append(errs, errors.New("migratedisk needs stickydisk"))
+	}
+	m := getMigrate(tg)
+	if m == nil {
+		return errs
+	}
+	if isBatch(tj) || isSystem(tj) {
+		return append(errs, fmt.Errorf("migrate: not supported for type %s", tj.Type))
+	}
+	switch m.HealthCheck {
+	case "", "checks", "task_states":
+	default:
+		errs = append(errs, fmt.Errorf("migrate: unknown healthcheck %s, use checks or task_states", m.HealthCheck))
+	}
+	if m.MaxParallel < 1 {
+		errs = append(errs, errors.New("migrate: maxparallel must be at least 1"))
+	}
+	min, healthy := "10s", "5m"
+	if m.MinHealthyTime != "" {
+		min = m.MinHealthyTime
+	}
+	if m.HealthyDeadline != "" {
+		healthy = m.HealthyDeadline
+	}
+	minD, err := time.ParseDuration(min)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("migrate: minhealthytime %s is not a duration", min))
+	}
+	healthyD, err2 := time.ParseDuration(healthy)
+	if err2 != nil {
+		errs = append(errs, fmt.Errorf("migrate: healthydeadline %s is not a duration", healthy))
+	}
+	if err == nil && err2 == nil && minD >= healthyD {
+		errs = append(errs, fmt.Errorf("migrate: minhealthytime %s must be less than healthydeadline %s", min, healthy))
+	}
+	return errs
+}
+
+func validateVolume(v Tvolume) []error {
+	var errs []error
+	if v.Name == "" {
+		return []error{errors.New("volume without name")}
+	}
+	if v.Source == "" {
+		errs = append(errs, fmt.Errorf("volume %s: needs a source", v.Name))
+	}
+	switch v.Type {
+	case "host":
+		if v.AccessMode != "" || v.AttachmentMode != "" {
+			errs = append(errs, fmt.Errorf("volume %s: accessmode and attachmentmode are only supported for csi volumes", v.Name))
+		}
+	case "csi":
+		if !csiAccessModes[v.AccessMode] {
+			errs = append(errs, fmt.Errorf("volume %s: unknown accessmode %s, use single-node-reader-only, single-node-writer, multi-node-reader-only, multi-node-single-writer or multi-node-multi-writer", v.Name, v.AccessMode))
+		}
+		if !csiAttachmentModes[v.AttachmentMode] {
+			errs = append(errs, fmt.Errorf("volume %s: unknown attachmentmode %s, use file-system or block-device", v.Name, v.AttachmentMode))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("volume %s: unknown type %s, use host or csi", v.Name, v.Type))
+	}
+	return errs
+}
+
+// validateVolumeString validates a docker volume source:target[:mode]
+func validateVolumeString(v string) error {
+	parts := strings.Split(v, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("volume %s: expected source:target or source:target:mode", v)
+	}
+	if parts[0] == "" {
+		return fmt.Errorf("volume %s: empty source", v)
+	}
+	if !strings.HasPrefix(parts[1], "/") {
+		return fmt.Errorf("volume %s: target %s is not an absolute path", v, parts[1])
+	}
+	if len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw" {
+		return fmt.Errorf("volume %s: unknown mode %s, use ro or rw", v, parts[2])
+	}
+	return nil
+}
+
+func validateMount(m Tmount) []error {
+	var errs []error
+	if !strings.HasPrefix(m.Target, "/") {
+		errs = append(errs, fmt.Errorf("mount %s: target is not an absolute path", m.Target))
+	}
+	switch m.Type {
+	case "bind", "volume":
+		if m.Source == "" {
+			errs = append(errs, fmt.Errorf("mount %s: type %s needs a source", m.Target, m.Type))
+		}
+		if m.Size != 0 {
+			errs = append(errs, fmt.Errorf("mount %s: size is only supported for tmpfs mounts", m.Target))
+		}
+	case "tmpfs":
+		if m.Source != "" {
+			errs = append(errs, fmt.Errorf("mount %s: tmpfs mounts don't have a source", m.Target))
+		}
+		if m.Size < 0 {
+			errs = append(errs, fmt.Errorf("mount %s: size can't be negative", m.Target))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("mount %s: unknown type %s, use bind, volume or tmpfs", m.Target, m.Type))
+	}
+	return errs
+}
+
+// validateDocker validates the docker options of the task against the allow-lists of the site.
+func validateDocker(tj *Tjob, task Ttask) []error {
+	var errs []error
+	for _, c := range task.CapAdd {
+		if !isAllowed(site.Docker.CapAdd, c) {
+			errs = append(errs, fmt.Errorf("capadd %s is not allowed by the site defaults", c))
+		}
+	}
+	for _, kv := range task.Sysctl {
+		key := strings.TrimSpace(strings.SplitN(kv, "=", 2)[0])
+		if !strings.Contains(kv, "=") {
+			errs = append(errs, fmt.Errorf("sysctl %s: expected key=value", kv))
+		} else if !isAllowed(site.Docker.Sysctl, key) {
+			errs = append(errs, fmt.Errorf("sysctl %s is not allowed by the site defaults", key))
+		}
+	}
+	for _, kv := range task.Ulimit {
+		res := strings.SplitN(kv, "=", 2)
+		name := strings.TrimSpace(res[0])
+		switch {
+		case len(res) != 2 || !ulimitRegexp.MatchString(strings.TrimSpace(res[1])):
+			errs = append(errs, fmt.Errorf("ulimit %s: expected name=soft or name=soft:hard", kv))
+		case !isAllowed(site.Docker.Ulimit, name):
+			errs = append(errs, fmt.Errorf("ulimit %s is not allowed by the site defaults", name))
+		}
+	}
+	for _, opt := range task.SecurityOpt {
+		if !isAllowed(site.Docker.SecurityOpt, opt) {
+			errs = append(errs, fmt.Errorf("securityopt %s is not allowed by the site defaults", opt))
+		}
+	}
+	if isTrue(task.Privileged) && !isAllowed(site.Docker.Privileged, tj.Team+"/"+tj.Project) {
+		errs = append(errs, fmt.Errorf("privileged is not approved for %s/%s in the site defaults", tj.Team, tj.Project))
+	}
+	if task.ShmSize < 0 {
+		errs = append(errs, errors.New("shmsize can't be negative"))
+	}
+	if task.WorkDir != "" && !strings.HasPrefix(task.WorkDir, "/") {
+		errs = append(errs, fmt.Errorf("workdir %s is not an absolute path", task.WorkDir))
+	}
+	for _, ip := range task.DNSServers {
+		if net.ParseIP(ip) == nil {
+			errs = append(errs, fmt.Errorf("dnsservers: %s is not an ip address", ip))
+		}
+	}
+	for _, h := range task.ExtraHosts {
+		res := strings.SplitN(h, ":", 2)
+		if len(res) != 2 || res[0] == "" || net.ParseIP(res[1]) == nil {
+			errs = append(errs, fmt.Errorf("extrahosts %s: expected host:ip", h))
+		}
+	}
+	return errs
+}
+
+// isAllowed returns true if value matches one of the glob patterns, ignoring case.
+func isAllowed(patterns []string, value string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(strings.ToLower(p), strings.ToLower(value)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validateTemplateSettings(ts Ttemplate) []error {
+	var errs []error
+	if _, err := filepath.Match(ts.File, ""); ts.File == "" || err != nil {
+		errs = append(errs, fmt.Errorf("template %s: invalid file pattern", ts.File))
+	}
+	switch ts.ChangeMode {
+	case "", "noop", "restart":
+	case "signal":
+		if ts.ChangeSignal == "" {
+			errs = append(errs, fmt.Errorf("template %s: changemode signal needs a changesignal", ts.File))
+		}
+	case "script":
+		if ts.ChangeScript.Command == "" {
+			errs = append(errs, fmt.Errorf("template %s: changemode script needs a changescript command", ts.File))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("template %s: unknown changemode %s", ts.File, ts.ChangeMode))
+	}
+	if ts.ChangeMode != "script" && ts.ChangeScript.Command != "" {
+		errs = append(errs, fmt.Errorf("template %s: changescript needs changemode script", ts.File))
+	}
+	if ts.Perms != "" && !permsRegexp.MatchString(ts.Perms) {
+		errs = append(errs, fmt.Errorf("template %s: perms %s is not octal", ts.File, ts.Perms))
+	}
+	return errs
+}
+
+func validateIdentity(id Tidentity) []error {
+	var errs []error
+	switch id.ChangeMode {
+	case "", "noop", "restart":
+	case "signal":
+		if id.ChangeSignal == "" {
+			errs = append(errs, fmt.Errorf("identity %s: changemode signal needs a changesignal", id.Name))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("identity %s: unknown changemode %s", id.Name, id.ChangeMode))
+	}
+	return errs
+}
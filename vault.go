@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/42wim/hclencoder"
+)
+
+// Vault policy structs
+type VaultPolicy struct {
+	Path []VaultPolicyPath `hcl:"path"`
+}
+
+type VaultPolicyPath struct {
+	Name         string   `hcl:",key"`
+	Capabilities []string `hcl:"capabilities"`
+}
+
+// a vault secret used by a task with the fields read by the templates
+type vaultSecret struct {
+	Path   string
+	Fields []string
+}
+
+// getVaultPathsForTask returns the vault secrets referenced by the vaultenv and vaultinject
+// settings and the registry credentials of a task, sorted by path.
+func getVaultPathsForTask(tj *Tjob, task Ttask) []vaultSecret {
+	m := make(map[string][]string)
+	for _, e := range task.VaultEnv {
+		_, fp := parseVaultEnv(tj, e)
+		p := getVaultSecretPath(tj, fp)
+		m[p] = mergeUnique(m[p], []string{"value"})
+	}
+	for _, entry := range task.VaultInject {
+		vaultKey := strings.Split(entry, ":")[0]
+		p := getVaultSecretPath(tj, tj.Project+"/"+vaultKey)
+		m[p] = mergeUnique(m[p], []string{"value"})
+	}
+	if p := getRegistryVaultPath(task); p != "" {
+		m[p] = mergeUnique(m[p], []string{"username", "password"})
+	}
+	secrets := []vaultSecret{}
+	for p, fields := range m {
+		secrets = append(secrets, vaultSecret{Path: p, Fields: fields})
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Path < secrets[j].Path })
+	return secrets
+}
+
+// getVaultPolicy returns a policy granting read access on exactly the paths the task uses.
+func getVaultPolicy(tj *Tjob, task Ttask) VaultPolicy {
+	policy := VaultPolicy{}
+	for _, secret := range getVaultPathsForTask(tj, task) {
+		policy.Path = append(policy.Path, VaultPolicyPath{Name: getVaultPolicyPath(secret.Path), Capabilities: []string{"read"}})
+	}
+	return policy
+}
+
+// getVaultPolicyPath returns the path a policy grants access on to read the secret at path,
+// kv version 2 reads the secrets below data/ of the mount, which is the first element of the path.
+func getVaultPolicyPath(path string) string {
+	if site.VaultKVVersion != 2 {
+		return path
+	}
+	p := strings.SplitN(path, "/", 2)
+	if len(p) < 2 {
+		return path
+	}
+	return p[0] + "/data/" + p[1]
+}
+
+// getVaultPolicyName returns the name of the policy generated for the task, it is named after the task.
+func getVaultPolicyName(tj *Tjob, task Ttask) string {
+	return getTaskName(tj, task)
+}
+
+// createVaultPolicies creates vault-policy-<policy name>.hcl files for every task using vault secrets
+// in the given tier.
+func createVaultPolicies(tj *Tjob, tier string) {
+	for _, task := range tj.Task {
+		policy := getVaultPolicy(tj, task)
+		if len(policy.Path) == 0 {
+			continue
+		}
+		for i := range policy.Path {
+			policy.Path[i].Name = strings.Replace(policy.Path[i].Name, "${short_tier}", getShortTier(tier), -1)
+		}
+		name := strings.Replace(getVaultPolicyName(tj, task), "${short_tier}", getShortTier(tier), -1)
+		res, err := hclencoder.Encode(policy)
+		if err != nil {
+			fmt.Printf("error: policy %s: %s\n", name, err)
+			continue
+		}
+		f := "vault-policy-" + name + ".hcl"
+		if err := ioutil.WriteFile(f, res, 0600); err != nil {
+			fmt.Printf("error: policy %s: %s\n", name, err)
+			continue
+		}
+		fmt.Printf("%s written (policy %s).\n", f, name)
+	}
+}
+
+// getVaultField returns the template expression reading a field of a vault secret,
+// kv version 2 nests the fields of the secret in data.
+func getVaultField(field string) string {
+	if site.VaultKVVersion == 2 {
+		return ".Data.data." + field
+	}
+	return ".Data." + field
+}
+
+// getShortTier returns the short form of a tier as used in ${short_tier}, eg production becomes p.
+func getShortTier(tier string) string {
+	if tier == "" {
+		return ""
+	}
+	return tier[:1]
+}
+
+// getVaultMount returns the mount of the secret at path and the version of its kv engine.
+// Without access to the mount information the version is empty and the secret is read as kv version 1.
+func getVaultMount(client *http.Client, addr, token, path string) (string, string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/sys/internal/ui/mounts/"+path, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", nil
+	}
+	var mount struct {
+		Data struct {
+			Path    string            `json:"path"`
+			Options map[string]string `json:"options"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mount); err != nil {
+		return "", "", err
+	}
+	if mount.Data.Options["version"] == "2" {
+		return mount.Data.Path, "2", nil
+	}
+	return mount.Data.Path, "1", nil
+}
+
+// readVaultSecret returns the data of the secret at path in vault and the version of its kv engine,
+// for kv version 1 and 2.
+func readVaultSecret(client *http.Client, addr, token, path string) (map[string]interface{}, string, error) {
+	mount, version, err := getVaultMount(client, addr, token, path)
+	if err != nil {
+		return nil, "", err
+	}
+	u := path
+	if version == "2" {
+		u = mount + "data/" + strings.TrimPrefix(path, mount)
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+u, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, "", errors.New("secret not found")
+	default:
+		return nil, "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, "", err
+	}
+	if version == "2" {
+		// kv version 2 wraps the data with its metadata
+		data, _ := secret.Data["data"].(map[string]interface{})
+		if data == nil {
+			// deleted versions have no data
+			return nil, "", errors.New("secret not found")
+		}
+		return data, version, nil
+	}
+	return secret.Data, version, nil
+}
+
+// checkVaultSecret checks if the secret at path exists in vault and has the fields
+// used by the generated templates, which read the kv version of the site defaults.
+func checkVaultSecret(client *http.Client, addr, token, path string, fields []string) error {
+	data, version, err := readVaultSecret(client, addr, token, path)
+	if err != nil {
+		return err
+	}
+	if version != "" && version != strconv.Itoa(site.VaultKVVersion) {
+		return fmt.Errorf("secret is in a kv version %s engine, the templates read kv version %d (vaultkvversion in the site defaults)", version, site.VaultKVVersion)
+	}
+	var missing []string
+	for _, f := range fields {
+		if _, ok := data[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("secret has no %s field", missing[0])
+	}
+	return fmt.Errorf("secret has no %s fields", strings.Join(missing, ", "))
+}
+
+// checkVaultSecrets verifies that all the secrets used by the tasks exist in vault for the given tier.
+// It returns false if secrets are missing.
+func checkVaultSecrets(tj *Tjob, tier string) bool {
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" {
+		fmt.Println("error: VAULT_ADDR is not set")
+		return false
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	ok := true
+	for _, task := range tj.Task {
+		missing := 0
+		for _, secret := range getVaultPathsForTask(tj, task) {
+			p := strings.Replace(secret.Path, "${short_tier}", getShortTier(tier), -1)
+			if err := checkVaultSecret(client, addr, token, p, secret.Fields); err != nil {
+				if missing == 0 {
+					fmt.Printf("task %s:\n", strings.Replace(getTaskName(tj, task), "${short_tier}", getShortTier(tier), -1))
+				}
+				fmt.Printf("  %s: %s\n", p, err)
+				missing++
+			}
+		}
+		if missing > 0 {
+			ok = false
+		}
+	}
+	if ok {
+		fmt.Printf("all secrets found for tier %s.\n", tier)
+	}
+	return ok
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newVaultServer returns a vault stand-in serving the secrets with kv version 1 on kv1/
+// and kv version 2 on secret/.
+func newVaultServer(t *testing.T, secrets map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		if strings.HasPrefix(path, "sys/internal/ui/mounts/") {
+			path = strings.TrimPrefix(path, "sys/internal/ui/mounts/")
+			mount := map[string]interface{}{"path": "kv1/", "type": "kv", "options": map[string]string{"version": "1"}}
+			if strings.HasPrefix(path, "secret/") {
+				mount = map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]string{"version": "2"}}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": mount})
+			return
+		}
+		var data map[string]interface{}
+		switch {
+		case strings.HasPrefix(path, "secret/data/"):
+			data = secrets["secret/"+strings.TrimPrefix(path, "secret/data/")]
+			if data != nil {
+				data = map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}}
+			}
+		case strings.HasPrefix(path, "kv1/"):
+			data = secrets[path]
+		}
+		if data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestCheckVaultSecret(t *testing.T) {
+	srv := newVaultServer(t, map[string]map[string]interface{}{
+		"kv1/app/found":       {"value": "x"},
+		"kv1/app/novalue":     {"other": "x"},
+		"secret/app/found":    {"value": "x"},
+		"secret/app/novalue":  {"other": "x"},
+		"secret/registry":     {"username": "u", "password": "p"},
+		"secret/registry/bad": {"username": "u"},
+	})
+	defer srv.Close()
+	value := []string{"value"}
+	credentials := []string{"username", "password"}
+	defer func(v int) { site.VaultKVVersion = v }(site.VaultKVVersion)
+	tests := []struct {
+		kv     int
+		path   string
+		fields []string
+		err    string
+	}{
+		{1, "kv1/app/found", value, ""},
+		{1, "kv1/app/missing", value, "secret not found"},
+		{1, "kv1/app/novalue", value, "secret has no value field"},
+		{2, "secret/app/found", value, ""},
+		{2, "secret/app/missing", value, "secret not found"},
+		{2, "secret/app/novalue", value, "secret has no value field"},
+		{2, "secret/app/novalue", credentials, "secret has no username, password fields"},
+		{2, "secret/registry", credentials, ""},
+		{2, "secret/registry", value, "secret has no value field"},
+		{2, "secret/registry/bad", credentials, "secret has no password field"},
+		{1, "secret/app/found", value, "secret is in a kv version 2 engine, the templates read kv version 1 (vaultkvversion in the site defaults)"},
+		{2, "kv1/app/found", value, "secret is in a kv version 1 engine, the templates read kv version 2 (vaultkvversion in the site defaults)"},
+	}
+	for _, tt := range tests {
+		site.VaultKVVersion = tt.kv
+		err := checkVaultSecret(srv.Client(), srv.URL, "token", tt.path, tt.fields)
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error %s", tt.path, err)
+		case tt.err != "" && (err == nil || err.Error() != tt.err):
+			t.Errorf("%s: expected error %q, got %v", tt.path, tt.err, err)
+		}
+	}
+}
+
+func TestGetVaultPolicyPath(t *testing.T) {
+	defer func(v int) { site.VaultKVVersion = v }(site.VaultKVVersion)
+	tests := []struct {
+		kv   int
+		path string
+		want string
+	}{
+		{1, "secret/projects/prefix-p-team/app/DB", "secret/projects/prefix-p-team/app/DB"},
+		{2, "secret/projects/prefix-p-team/app/DB", "secret/data/projects/prefix-p-team/app/DB"},
+		{2, "secret", "secret"},
+	}
+	for _, tt := range tests {
+		site.VaultKVVersion = tt.kv
+		if got := getVaultPolicyPath(tt.path); got != tt.want {
+			t.Errorf("kv %d %s: expected %s, got %s", tt.kv, tt.path, tt.want, got)
+		}
+	}
+}
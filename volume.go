@@ -0,0 +1,62 @@
+package main
+
+var csiAccessModes = map[string]bool{
+	"single-node-reader-only":  true,
+	"single-node-writer":       true,
+	"multi-node-reader-only":   true,
+	"multi-node-single-writer": true,
+	"multi-node-multi-writer":  true,
+}
+
+var csiAttachmentModes = map[string]bool{
+	"file-system":  true,
+	"block-device": true,
+}
+
+// getVolumes returns the host and csi volumes of the taskgroup.
+func getVolumes(tg *Tgroup) []Volume {
+	var volumes []Volume
+	for _, v := range tg.Volume {
+		volumes = append(volumes, Volume{
+			Name:           v.Name,
+			Type:           v.Type,
+			Source:         v.Source,
+			ReadOnly:       v.ReadOnly,
+			AccessMode:     v.AccessMode,
+			AttachmentMode: v.AttachmentMode,
+			PerAlloc:       v.PerAlloc,
+		})
+	}
+	return volumes
+}
+
+// getVolumeMounts returns the mounts of taskgroup volumes in the task.
+func getVolumeMounts(task Ttask) []VolumeMount {
+	var mounts []VolumeMount
+	for _, m := range task.VolumeMount {
+		mounts = append(mounts, VolumeMount{
+			Volume:      m.Volume,
+			Destination: m.Destination,
+			ReadOnly:    m.ReadOnly,
+		})
+	}
+	return mounts
+}
+
+// getMounts returns the docker mounts of the task.
+func getMounts(task Ttask) []Mount {
+	var mounts []Mount
+	for _, m := range task.Mount {
+		mount := Mount{
+			Type:     m.Type,
+			Target:   m.Target,
+			Source:   m.Source,
+			ReadOnly: m.ReadOnly,
+		}
+		if m.Type == "tmpfs" && m.Size > 0 {
+			mount.TmpfsOptions = &TmpfsOptions{Size: m.Size}
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts
+}